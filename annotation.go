@@ -0,0 +1,70 @@
+package jsonschema
+
+// Annotation captures an annotation produced during validation.
+type Annotation struct {
+	KeywordLocation         string      // validation path of annotating keyword
+	AbsoluteKeywordLocation string      // absolute location of annotating keyword
+	InstanceLocation        string      // location of the json value annotated
+	Keyword                 string      // annotating keyword
+	Value                   interface{} // annotation value
+}
+
+// Annotations captures annotations grouped by instance location.
+type Annotations map[string][]Annotation
+
+// Get returns the values of given keyword annotated at instance location vloc.
+func (a Annotations) Get(vloc string, keyword string) []interface{} {
+	var values []interface{}
+	for _, an := range a[vloc] {
+		if an.Keyword == keyword {
+			values = append(values, an.Value)
+		}
+	}
+	return values
+}
+
+// collectAnnotations returns annotations produced by keywords of s,
+// when applied to json value at vloc.
+func (s *Schema) collectAnnotations(scope []schemaRef, vloc string) []Annotation {
+	var annotations []Annotation
+	add := func(keyword string, value interface{}) {
+		annotations = append(annotations, Annotation{
+			KeywordLocation:         keywordLocation(scope, keyword),
+			AbsoluteKeywordLocation: joinPtr(s.Location, keyword),
+			InstanceLocation:        vloc,
+			Keyword:                 keyword,
+			Value:                   value,
+		})
+	}
+	if s.Title != "" {
+		add("title", s.Title)
+	}
+	if s.Description != "" {
+		add("description", s.Description)
+	}
+	if s.Default != nil {
+		add("default", s.Default)
+	}
+	if s.Format != "" {
+		add("format", s.Format)
+	}
+	if s.ContentEncoding != "" {
+		add("contentEncoding", s.ContentEncoding)
+	}
+	if s.ContentMediaType != "" {
+		add("contentMediaType", s.ContentMediaType)
+	}
+	if s.ReadOnly {
+		add("readOnly", true)
+	}
+	if s.WriteOnly {
+		add("writeOnly", true)
+	}
+	if len(s.Examples) > 0 {
+		add("examples", s.Examples)
+	}
+	if s.Deprecated {
+		add("deprecated", true)
+	}
+	return annotations
+}
@@ -0,0 +1,120 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Bundle returns the schema at given url along with all the schema documents
+// it refers, transitively, as single self-contained schema document.
+//
+// The referred documents are embedded in "$defs" of the root document, each with
+// "$id" set to its canonical url. So $refs need not be rewritten, and bundled
+// document validates same as the original documents. A document that declares
+// "$id" different from the url it is referred by, is also embedded under that url.
+//
+// Only documents of draft 2019-09 or later can be bundled, and all the documents
+// must use same draft. Note that url must not have fragment.
+func (c *Compiler) Bundle(url string) (json.RawMessage, error) {
+	u, err := toAbs(url)
+	if err != nil {
+		return nil, &SchemaError{url, err}
+	}
+	if _, err := c.Compile(u); err != nil {
+		return nil, err
+	}
+	r := c.resources[u]
+	if r == nil {
+		return nil, &SchemaError{u, fmt.Errorf("jsonschema: cannot bundle %s", url)}
+	}
+	if r.draft.version < 2019 {
+		return nil, &SchemaError{u, fmt.Errorf("jsonschema: bundling requires draft 2019-09 or later, but got %s", r.draft)}
+	}
+
+	root := withID(deepCopy(r.doc), r.url)
+	defs, ok := root["$defs"].(map[string]interface{})
+	if !ok {
+		defs = make(map[string]interface{})
+	}
+	embed := func(u string, doc interface{}) {
+		name := path.Base(strings.TrimSuffix(u, "/"))
+		if name == "" || name == "." || name == "/" {
+			name = "schema"
+		}
+		key := name
+		for i := 1; defs[key] != nil; i++ {
+			key = name + "-" + strconv.Itoa(i)
+		}
+		defs[key] = doc
+	}
+
+	queue := externalRefs(u, r.doc)
+	seen := map[string]bool{r.url: true}
+	embedded := map[*resource]bool{r: true}
+	for len(queue) > 0 {
+		ref := queue[0]
+		queue = queue[1:]
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		if _, ok := vocabSchemas[ref]; ok || findDraft(ref) != nil {
+			continue
+		}
+		res, err := c.resourceOf(ref)
+		if err != nil {
+			return nil, &SchemaError{u, err}
+		}
+		if res.draft != r.draft {
+			return nil, &SchemaError{u, fmt.Errorf("jsonschema: cannot bundle %s of %s with %s", ref, res.draft, r.draft)}
+		}
+		if !embedded[res] {
+			embedded[res] = true
+			embed(res.url, withID(deepCopy(res.doc), res.url))
+			queue = append(queue, externalRefs(res.url, res.doc)...)
+		}
+		if c.resources[ref] == res && res.url != ref {
+			// referred by retrieval url
+			embed(ref, map[string]interface{}{"$id": ref, "$ref": res.url})
+		}
+	}
+
+	if len(defs) > 0 {
+		root["$defs"] = defs
+	}
+	return json.Marshal(root)
+}
+
+// resourceOf returns the document which has subschema with given url,
+// loading it if necessary.
+func (c *Compiler) resourceOf(url string) (*resource, error) {
+	if _, ok := c.resources[url]; !ok {
+		for _, r := range c.resources {
+			if r.draft != nil && r.findResource(url) != nil {
+				return r, nil
+			}
+		}
+		if err := c.checkLoad(url); err != nil {
+			return nil, err
+		}
+	}
+	return c.findResource(url)
+}
+
+// withID returns schema doc as object, with id set to given url.
+func withID(doc interface{}, url string) map[string]interface{} {
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		doc["$id"] = url
+		return doc
+	case bool:
+		if doc {
+			return map[string]interface{}{"$id": url}
+		}
+		return map[string]interface{}{"$id": url, "not": map[string]interface{}{}}
+	}
+	return nil
+}
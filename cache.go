@@ -0,0 +1,86 @@
+package jsonschema
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+// CachedValidator validates json documents against a schema, remembering
+// the results of most recently validated documents. A document identical,
+// byte by byte, to one in the cache is not decoded or validated again.
+// It is meant for hot paths where same documents are validated repeatedly.
+//
+// Documents are identified by sha256 hash of their bytes, so documents that
+// differ only in whitespace or key order are cached separately.
+//
+// A CachedValidator is safe for concurrent use. The errors it returns are
+// shared by the callers that validate identical documents, so they must not
+// be modified.
+type CachedValidator struct {
+	s     *Schema
+	size  int
+	mu    sync.Mutex
+	lru   *list.List // of *cacheEntry, most recently used first
+	items map[[sha256.Size]byte]*list.Element
+}
+
+type cacheEntry struct {
+	key [sha256.Size]byte
+	err error
+}
+
+// CachedValidator returns a new CachedValidator, which validates against s
+// and caches the results of at most size documents. If size is less than 1,
+// results are not cached.
+func (s *Schema) CachedValidator(size int) *CachedValidator {
+	return &CachedValidator{
+		s:     s,
+		size:  size,
+		lru:   list.New(),
+		items: make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// ValidateBytes decodes json document b and validates it, like
+// Schema.ValidateBytes. If identical document was validated recently,
+// its result is returned instead.
+func (cv *CachedValidator) ValidateBytes(b []byte) error {
+	if cv.size < 1 {
+		return cv.s.ValidateBytes(b)
+	}
+	key := sha256.Sum256(b)
+	cv.mu.Lock()
+	if e, ok := cv.items[key]; ok {
+		cv.lru.MoveToFront(e)
+		err := e.Value.(*cacheEntry).err
+		cv.mu.Unlock()
+		return err
+	}
+	cv.mu.Unlock()
+
+	// validate without holding lock, so that misses do not serialize
+	err := cv.s.ValidateBytes(b)
+
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	if e, ok := cv.items[key]; ok {
+		// validated concurrently by another goroutine
+		cv.lru.MoveToFront(e)
+		return err
+	}
+	cv.items[key] = cv.lru.PushFront(&cacheEntry{key, err})
+	if cv.lru.Len() > cv.size {
+		e := cv.lru.Back()
+		cv.lru.Remove(e)
+		delete(cv.items, e.Value.(*cacheEntry).key)
+	}
+	return err
+}
+
+// Len returns the number of documents whose results are cached.
+func (cv *CachedValidator) Len() int {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	return cv.lru.Len()
+}
@@ -0,0 +1,162 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ValidateAndCoerce converts string values in doc to the scalar type
+// declared for them by the json-schema s, and validates the result against s.
+// This is useful for form data or query parameters, where every value
+// arrives as string.
+//
+// A string is coerced only if all the subschemas applicable at its location,
+// through "properties", "patternProperties", "additionalProperties", "items",
+// "prefixItems", "$ref" and "allOf", declare exactly one and same "type" of
+// boolean, integer, number or null. So values with multiple types allowed,
+// for example "type": ["integer", "string"], are left as-is. Strings that
+// cannot be converted, such as "abc" for integer, are left as-is as well,
+// and are reported as type errors by validation.
+//
+// Numbers are coerced to json.Number, or to float64 if s is compiled with
+// Compiler.UseNumber false. Objects and arrays in doc are modified in place.
+// The coerced doc is returned, even if validation fails.
+func (s *Schema) ValidateAndCoerce(doc interface{}) (interface{}, error) {
+	doc = coerce([]*Schema{s}, doc, !s.useFloat)
+	return doc, s.Validate(doc)
+}
+
+// coerce converts v to the scalar type declared by schemas, and coerces
+// the members of v against their applicable subschemas.
+func coerce(schemas []*Schema, v interface{}, useNumber bool) interface{} {
+	schemas = expandCoerce(schemas, nil)
+	if len(schemas) == 0 {
+		return v
+	}
+	switch v := v.(type) {
+	case string:
+		return coerceString(schemas, v, useNumber)
+	case map[string]interface{}:
+		for pname, pvalue := range v {
+			var applicable []*Schema
+			for _, sch := range schemas {
+				evaluated := false
+				if psch, ok := sch.Properties[pname]; ok {
+					applicable, evaluated = append(applicable, psch), true
+				}
+				for re, psch := range sch.PatternProperties {
+					if re.MatchString(pname) {
+						applicable, evaluated = append(applicable, psch), true
+					}
+				}
+				if additional, ok := sch.AdditionalProperties.(*Schema); ok && !evaluated {
+					applicable = append(applicable, additional)
+				}
+			}
+			v[pname] = coerce(applicable, pvalue, useNumber)
+		}
+	case []interface{}:
+		for i, item := range v {
+			var applicable []*Schema
+			for _, sch := range schemas {
+				switch items := sch.Items.(type) {
+				case *Schema:
+					applicable = append(applicable, items)
+				case []*Schema:
+					if i < len(items) {
+						applicable = append(applicable, items[i])
+					} else if additional, ok := sch.AdditionalItems.(*Schema); ok {
+						applicable = append(applicable, additional)
+					}
+				}
+				if i < len(sch.PrefixItems) {
+					applicable = append(applicable, sch.PrefixItems[i])
+				} else if sch.Items2020 != nil {
+					applicable = append(applicable, sch.Items2020)
+				}
+			}
+			v[i] = coerce(applicable, item, useNumber)
+		}
+	}
+	return v
+}
+
+// expandCoerce appends to list, schemas along with the schemas they
+// apply in place via $ref and allOf. each schema is appended once.
+func expandCoerce(schemas []*Schema, list []*Schema) []*Schema {
+outer:
+	for _, sch := range schemas {
+		for _, s := range list {
+			if s == sch {
+				continue outer
+			}
+		}
+		list = append(list, sch)
+		if sch.Ref != nil {
+			list = expandCoerce([]*Schema{sch.Ref}, list)
+		}
+		list = expandCoerce(sch.AllOf, list)
+	}
+	return list
+}
+
+// coerceString converts str to the single scalar type declared by schemas.
+// returns str as is, if there is no such type, or str cannot be converted.
+func coerceString(schemas []*Schema, str string, useNumber bool) interface{} {
+	typ := ""
+	for _, sch := range schemas {
+		switch len(sch.Types) {
+		case 0:
+			continue
+		case 1:
+			if typ == "" || typ == sch.Types[0] {
+				typ = sch.Types[0]
+				continue
+			}
+		}
+		return str // ambiguous
+	}
+	switch typ {
+	case "boolean":
+		switch str {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+	case "null":
+		if str == "null" {
+			return nil
+		}
+	case "integer", "number":
+		v, ok := decodeNumber(str, useNumber)
+		if !ok {
+			return str
+		}
+		if typ == "integer" && !rat(v).IsInt() {
+			return str
+		}
+		return v
+	}
+	return str
+}
+
+// decodeNumber decodes str, if it is exactly one json number with
+// no surrounding whitespace or trailing characters.
+func decodeNumber(str string, useNumber bool) (interface{}, bool) {
+	if strings.TrimSpace(str) != str {
+		return nil, false
+	}
+	decoder := json.NewDecoder(strings.NewReader(str))
+	if useNumber {
+		decoder.UseNumber()
+	}
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil || decoder.InputOffset() != int64(len(str)) {
+		return nil, false
+	}
+	if jsonType(v) != "number" {
+		return nil, false
+	}
+	return v, true
+}
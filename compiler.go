@@ -1,22 +1,42 @@
 package jsonschema
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"math/big"
+	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // A Compiler represents a json-schema compiler.
+//
+// A Compiler must not be used concurrently by multiple goroutines. The
+// compiled schemas however are safe for concurrent use.
 type Compiler struct {
 	// Draft represents the draft used when '$schema' attribute is missing.
 	//
 	// This defaults to latest supported draft (currently 2020-12).
 	Draft     *Draft
 	resources map[string]*resource
+	ctx       context.Context // context of current CompileContext call
+
+	// OpenAPI tells whether schemas are in OpenAPI 3.1 dialect, which is
+	// draft 2020-12 with the annotation keywords "discriminator", "xml",
+	// "externalDocs" and "example". If set, schemas without '$schema' use
+	// draft 2020-12, Strict allows these keywords, and "example" is added
+	// to Schema.Examples if ExtractAnnotations is set.
+	//
+	// Schemas with '$schema' of OpenAPI 3.1 dialect url are treated so,
+	// even if this is not set. The dialect metaschema is available offline.
+	OpenAPI bool
 
 	// Extensions is used to register extensions.
 	extensions map[string]extension
@@ -25,34 +45,181 @@ type Compiler struct {
 	// in compiled Schema or not.
 	ExtractAnnotations bool
 
-	// LoadURL loads the document at given absolute URL.
+	// Loader loads the documents that are not added using AddResource.
+	//
+	// If not nil, it is used instead of LoadURLContext and LoadURL.
+	Loader ResourceLoader
+
+	// LoadURL loads the document at given absolute URL. It is a convenience
+	// for loaders that are plain functions, used only if Loader is nil.
 	//
 	// If nil, package global LoadURL is used.
 	LoadURL func(s string) (io.ReadCloser, error)
 
+	// LoadURLContext loads the document at given absolute URL, using the
+	// ctx passed to CompileContext or context.Background().
+	//
+	// If not nil, it is used instead of LoadURL, unless Loader is set.
+	LoadURLContext func(ctx context.Context, s string) (io.ReadCloser, error)
+
+	// AllowRemoteRefs tells whether documents referred by $ref or $schema can be
+	// loaded using Loader or LoadURL, irrespective of url scheme. If false, such documents
+	// must be added using AddResource, unless their host is in AllowedHosts.
+	// The document passed to Compile is always loaded.
+	//
+	// This defaults to true.
+	AllowRemoteRefs bool
+
+	// AllowedHosts lists the hosts, whose documents can be loaded even if
+	// AllowRemoteRefs is false. An entry can be hostname or host:port.
+	AllowedHosts []string
+
+	// LazyRefs tells whether $ref, $recursiveRef and $dynamicRef to documents
+	// that are neither added using AddResource nor already loaded, are skipped
+	// instead of being loaded. Such ref compiles to a schema that is always
+	// valid, whose Location is the url referred.
+	//
+	// So the compiled schema does only partial validation: an instance valid
+	// against it may still be invalid against the referred schemas. Refs that
+	// can be resolved locally are compiled as usual, and a missing fragment in
+	// such document still fails compilation.
+	LazyRefs bool
+
+	// MaxConcurrentLoads is the number of documents loaded concurrently.
+	//
+	// If greater than 1, Compile first discovers the external documents
+	// referred by $ref, transitively, and loads them concurrently before
+	// compiling. So the loader must be safe for concurrent use. Documents that
+	// fail to load are loaded again during compilation, thus errors are reported
+	// same as in sequential loading.
+	//
+	// If zero or one, documents are loaded one by one as they are referred.
+	MaxConcurrentLoads int
+
 	// CompileRegex comples given regular expression.
+	// It is used for "pattern" and "patternProperties".
 	// Defaults to golang's regexp implementation.
 	//
 	// NOTE: If you are overriding this, also ensure to override "regex" Format.
+	// Formats of this compiler are also used when validating schemas
+	// against metaschema, so c.Formats["regex"] can be used for that.
+	//
+	// Compiled regexes are cached by pattern and shared by the schemas compiled
+	// by this compiler. So it must be set before compiling any schema.
 	CompileRegex func(s string) (Regexp, error)
+	regexes      map[string]Regexp // cache of CompileRegex
 
 	// Formats can be registered by adding to this map. Key is format name,
 	// value is function that knows how to validate that format.
+	// Formats not found here are looked up in the global Formats map.
 	Formats map[string]func(interface{}) bool
 
+	// FormatsWithDetail is like Formats, but its functions return error
+	// telling why the value is not valid, or nil if it is valid. The error
+	// text is reported as msg.Format.Detail. A format found here takes
+	// precedence over the same format in Formats. Formats not found here
+	// or in Formats are looked up in the global FormatsWithDetail and
+	// Formats maps, in that order.
+	FormatsWithDetail map[string]func(interface{}) error
+
 	// AssertFormat for specifications >= draft2019-09.
 	AssertFormat bool
 
+	// FormatMode tells how "format" keyword is validated. It overrides
+	// AssertFormat, unless it is FormatDefault.
+	FormatMode FormatMode
+
 	// Decoders can be registered by adding to this map. Key is encoding name,
 	// value is function that knows how to decode string in that format.
+	// Decoders not found here are looked up in the global Decoders map.
 	Decoders map[string]func(string) ([]byte, error)
 
 	// MediaTypes can be registered by adding to this map. Key is mediaType name,
 	// value is function that knows how to validate that mediaType.
+	// MediaTypes not found here are looked up in the global MediaTypes map.
 	MediaTypes map[string]func([]byte) error
 
 	// AssertContent for specifications >= draft2019-09.
+	// In draft7, content is always asserted.
 	AssertContent bool
+
+	// Translator is used to translate the messages of validation errors.
+	// If nil, messages are in english.
+	Translator Translator
+
+	// Strict tells whether to reject schemas with keywords unknown to their draft.
+	// Keywords with "x-" prefix, errorMessage and keywords of registered extensions are allowed.
+	Strict bool
+
+	// TreatNullableKeyword tells whether to interpret "nullable": true of
+	// OpenAPI 3.0 and Swagger 2.0 schemas, as allowing null in addition to
+	// the types in "type" keyword. It has no effect on schemas without "type".
+	// If not set, "nullable" is unknown keyword, which is ignored unless Strict
+	// is set.
+	TreatNullableKeyword bool
+
+	// Lint tells whether to look for keywords that have no effect, because
+	// they do not apply to the types allowed by "type" keyword of their schema.
+	// For example "minimum" with "type": "string". Such schemas are valid, so
+	// compilation does not fail; the findings are appended to LintWarnings.
+	Lint bool
+
+	// LintWarnings are the findings of Lint, for the last call to Compile.
+	// It is reset by each Compile. Each schema is linted once, when it is
+	// compiled for the first time, so schemas compiled by earlier calls are
+	// not reported again.
+	LintWarnings []LintWarning
+
+	// PreserveOrder tells whether to remember the declaration order of
+	// "properties" in the schema documents. If set, Schema.PropertyOrder
+	// is populated. It must be set before adding or loading any resource.
+	PreserveOrder bool
+
+	// AllowComments tells whether schema documents may have "//" and
+	// "/* */" comments and trailing commas, in the style of JSONC. This is
+	// leniency for hand-authored schemas only. Instances validated by the
+	// compiled schemas must still be strict json. It must be set before
+	// adding or loading any resource.
+	AllowComments bool
+
+	// MaxSchemaDepth limits the nesting depth of json values in each schema document.
+	// Compile fails if any loaded document exceeds it. Zero means no limit.
+	MaxSchemaDepth int
+
+	// MaxRefDepth limits the length of $ref chain being compiled, i.e. a $ref to
+	// schema with $ref to schema with $ref and so on. $recursiveRef and $dynamicRef
+	// are counted as $ref. Compile fails if it is exceeded. Zero means no limit.
+	MaxRefDepth int
+	refDepth    int // length of $ref chain currently being compiled
+
+	// MaxValidationDepth limits the nesting of schemas applied during validation,
+	// so that deeply nested instance cannot exhaust the stack. If exceeded, validation
+	// of compiled schemas return DepthError. Zero means no limit, which is the default.
+	//
+	// Set this when untrusted instances are validated against recursive schemas.
+	MaxValidationDepth int
+
+	// MarshalUnknownTypes tells whether values that are not json values, such as
+	// time.Time or types implementing json.Marshaler, are converted to json using
+	// json.Marshal before validation by compiled schemas. If conversion fails,
+	// validation returns InvalidJSONTypeError.
+	//
+	// Note that such value is validated as a copy. For example, defaults applied
+	// by Schema.ValidateAndApplyDefaults are not reflected in it.
+	MarshalUnknownTypes bool
+
+	// UseNumber tells whether numbers in json documents decoded by compiled schemas,
+	// such as in Schema.ValidateBytes, are decoded as json.Number. If false, they are
+	// decoded as float64, which may lose precision. In either case, a number whose
+	// value is whole, like 1.0, is an integer for "type": "integer".
+	//
+	// This defaults to true. Schema documents are always decoded with json.Number.
+	UseNumber bool
+
+	// TrackPositions tells whether to report the position of invalid json values,
+	// as ValidationError.Position, when compiled schemas validate documents they
+	// decode, such as in Schema.ValidateBytes. It costs an extra scan of the document.
+	TrackPositions bool
 }
 
 // Compile parses json-schema at given url returns, if successful,
@@ -70,6 +237,10 @@ func MustCompile(url string) *Schema {
 }
 
 // CompileString parses and compiles the given schema with given base url.
+//
+// url is used to resolve relative $ref in schema and as location of the
+// compiled schema in errors. It is resolved relative to current directory
+// just like Compile does.
 func CompileString(url, schema string) (*Schema, error) {
 	c := NewCompiler()
 	if err := c.AddResource(url, strings.NewReader(schema)); err != nil {
@@ -78,31 +249,51 @@ func CompileString(url, schema string) (*Schema, error) {
 	return c.Compile(url)
 }
 
+// CompileReader parses and compiles the self-contained schema read from r.
+//
+// Schema is given a synthetic base url. So $ref to any other document, except
+// the metaschemas of supported drafts, fails compilation.
+func CompileReader(r io.Reader) (*Schema, error) {
+	const url = "inline:///schema.json"
+	c := NewCompiler()
+	c.AllowRemoteRefs = false
+	if err := c.AddResource(url, r); err != nil {
+		return nil, err
+	}
+	return c.Compile(url)
+}
+
 // MustCompileString is like CompileString but panics on error.
-// It simplified safe initialization of global variables holding compiled Schema.
+// It simplifies safe initialization of global variables holding compiled Schema.
 func MustCompileString(url, schema string) *Schema {
-	c := NewCompiler()
-	if err := c.AddResource(url, strings.NewReader(schema)); err != nil {
-		panic(err)
+	s, err := CompileString(url, schema)
+	if err != nil {
+		panic(fmt.Sprintf("jsonschema: %#v", err))
 	}
-	return c.MustCompile(url)
+	return s
 }
 
 // NewCompiler returns a json-schema Compiler object.
-// if '$schema' attribute is missing, it is treated as draft7. to change this
-// behavior change Compiler.Draft value
+//
+// Each document is compiled with the draft declared by its '$schema' attribute,
+// so documents of different drafts can refer each other. if '$schema' attribute
+// is missing, it is treated as latest draft. to change this behavior change
+// Compiler.Draft value
 func NewCompiler() *Compiler {
 	return &Compiler{
-		Draft:     latest,
-		resources: make(map[string]*resource),
-		Formats:   make(map[string]func(interface{}) bool),
+		Draft:             latest,
+		resources:         make(map[string]*resource),
+		Formats:           make(map[string]func(interface{}) bool),
+		FormatsWithDetail: make(map[string]func(interface{}) error),
 		CompileRegex: func(s string) (Regexp, error) {
 			re, err := regexp.Compile(s)
 			return (*goRegexp)(re), err
 		},
-		Decoders:   make(map[string]func(string) ([]byte, error)),
-		MediaTypes: make(map[string]func([]byte) error),
-		extensions: make(map[string]extension),
+		Decoders:        make(map[string]func(string) ([]byte, error)),
+		MediaTypes:      make(map[string]func([]byte) error),
+		extensions:      make(map[string]extension),
+		AllowRemoteRefs: true,
+		UseNumber:       true,
 	}
 }
 
@@ -110,23 +301,158 @@ func NewCompiler() *Compiler {
 //
 // Note that url must not have fragment
 func (c *Compiler) AddResource(url string, r io.Reader) error {
-	doc, err := unmarshal(r)
+	doc, order, err := c.decode(r)
 	if err != nil {
 		return fmt.Errorf("jsonschema: invalid json %s: %v", url, err)
 	}
-	return c.AddResourceJSON(url, doc)
+	return c.addResource(url, doc, order)
 }
 
 // AddResourceJSON adds in-memory resource from given json value.
+//
+// Note that key order is not known for such resource. So PropertyOrder
+// of its schemas is sorted even if PreserveOrder is set.
 func (c *Compiler) AddResourceJSON(url string, doc interface{}) error {
+	return c.addResource(url, doc, nil)
+}
+
+func (c *Compiler) addResource(url string, doc interface{}, order map[string][]string) error {
 	res, err := newResource(url, doc)
 	if err != nil {
 		return err
 	}
+	res.order = order
 	c.resources[res.url] = res
 	return nil
 }
 
+// Clone returns a copy of c, which can be configured and used independently
+// of c. Maps and slices of configuration such as Formats and AllowedHosts are
+// copied, but their values such as functions are shared.
+//
+// Resources added to c, or loaded by it, are also available in the copy. Their
+// json documents were decoded by c when added, so readers passed to AddResource
+// are not read again. Schemas compiled by c are not shared; the copy compiles
+// resources again, with its own configuration, including CompileRegex.
+func (c *Compiler) Clone() *Compiler {
+	clone := *c
+	clone.ctx = nil
+	clone.refDepth = 0
+	clone.LintWarnings = nil
+	clone.resources = make(map[string]*resource, len(c.resources))
+	for url, r := range c.resources {
+		if url != r.url {
+			continue // alias of declared id, registered again when loaded
+		}
+		clone.resources[url] = &resource{url: r.url, floc: r.floc, doc: r.doc, order: r.order, builtin: r.builtin}
+	}
+	clone.extensions = make(map[string]extension, len(c.extensions))
+	for name, ext := range c.extensions {
+		clone.extensions[name] = ext
+	}
+	clone.regexes = nil // the copy may use another CompileRegex
+	if c.Formats != nil {
+		clone.Formats = make(map[string]func(interface{}) bool, len(c.Formats))
+		for name, f := range c.Formats {
+			clone.Formats[name] = f
+		}
+	}
+	if c.FormatsWithDetail != nil {
+		clone.FormatsWithDetail = make(map[string]func(interface{}) error, len(c.FormatsWithDetail))
+		for name, f := range c.FormatsWithDetail {
+			clone.FormatsWithDetail[name] = f
+		}
+	}
+	if c.Decoders != nil {
+		clone.Decoders = make(map[string]func(string) ([]byte, error), len(c.Decoders))
+		for name, d := range c.Decoders {
+			clone.Decoders[name] = d
+		}
+	}
+	if c.MediaTypes != nil {
+		clone.MediaTypes = make(map[string]func([]byte) error, len(c.MediaTypes))
+		for name, mt := range c.MediaTypes {
+			clone.MediaTypes[name] = mt
+		}
+	}
+	if c.AllowedHosts != nil {
+		clone.AllowedHosts = append([]string(nil), c.AllowedHosts...)
+	}
+	return &clone
+}
+
+// decode decodes json document from r. If c.PreserveOrder is set,
+// it also returns the key order of json objects in the document.
+// If c.AllowComments is set, comments and trailing commas are stripped
+// before decoding.
+func (c *Compiler) decode(r io.Reader) (interface{}, map[string][]string, error) {
+	if !c.PreserveOrder && !c.AllowComments {
+		doc, err := unmarshal(r)
+		return doc, nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.AllowComments {
+		if data, err = stripComments(data); err != nil {
+			return nil, nil, err
+		}
+	}
+	doc, err := unmarshal(bytes.NewReader(data))
+	if err != nil || !c.PreserveOrder {
+		return doc, nil, err
+	}
+	order, err := keyOrder(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, order, nil
+}
+
+// AddResourceFromFS adds files in fsys matching given pattern as in-memory resources.
+// pattern syntax is same as in fs.Glob.
+//
+// Each file is added with its path in fsys resolved against baseURL as url, so it
+// does not depend on current directory. baseURL must be absolute url, such as
+// "mem:///" or "https://example.com/schemas/"; it is treated as directory even
+// without trailing slash. Relative $ref between the added files resolve against
+// their paths in fsys. For example, after adding "schemas/*.json" with baseURL
+// "mem:///", use Compile("mem:///schemas/main.json") to compile one of them.
+func (c *Compiler) AddResourceFromFS(fsys fs.FS, baseURL, pattern string) error {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	}
+	if !base.IsAbs() {
+		return fmt.Errorf("jsonschema: base url %q is not absolute", baseURL)
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	matches, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+	for _, name := range matches {
+		// fs paths always use forward slashes, even on windows
+		u, err := resolveURL(baseURL, name)
+		if err != nil {
+			return err
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		err = c.AddResource(u, f)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // MustCompile is like Compile but panics if the url cannot be compiled to *Schema.
 // It simplifies safe initialization of global variables holding compiled Schemas.
 func (c *Compiler) MustCompile(url string) *Schema {
@@ -140,6 +466,10 @@ func (c *Compiler) MustCompile(url string) *Schema {
 // Compile parses json-schema at given url returns, if successful,
 // a Schema object that can be used to match against json.
 //
+// Each loaded document is validated against the metaschema of its draft,
+// before it is compiled. If not valid, the *SchemaError returned wraps
+// the *ValidationError, whose instance locations point into the document.
+//
 // error returned will be of type *SchemaError
 func (c *Compiler) Compile(url string) (*Schema, error) {
 	// make url absolute
@@ -148,7 +478,11 @@ func (c *Compiler) Compile(url string) (*Schema, error) {
 		return nil, &SchemaError{url, err}
 	}
 	url = u
+	c.LintWarnings = nil
 
+	if c.MaxConcurrentLoads > 1 && !c.LazyRefs {
+		c.prefetch(url)
+	}
 	sch, err := c.compileURL(url, nil, "#")
 	if err != nil {
 		err = &SchemaError{url, err}
@@ -156,20 +490,294 @@ func (c *Compiler) Compile(url string) (*Schema, error) {
 	return sch, err
 }
 
+// CompileContext is like Compile, but aborts compilation if ctx is done.
+//
+// ctx is checked before loading each resource and is passed to LoadURLContext.
+func (c *Compiler) CompileContext(ctx context.Context, url string) (*Schema, error) {
+	prev := c.ctx
+	c.ctx = ctx
+	defer func() { c.ctx = prev }()
+	return c.Compile(url)
+}
+
+// findDeclared returns the resource added with different retrieval url,
+// which declares url as its id. returns nil, if there is no such resource.
+func (c *Compiler) findDeclared(url string) (*resource, error) {
+	var urls []string
+	for rurl, r := range c.resources {
+		if r.draft != nil {
+			continue
+		}
+		if m, ok := r.doc.(map[string]interface{}); ok {
+			for _, kw := range []string{"$id", "id"} {
+				if id, ok := m[kw].(string); ok {
+					if id, err := resolveURL(rurl, id); err == nil {
+						if id, _ := split(id); id == url {
+							urls = append(urls, rurl)
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(urls)
+	for _, rurl := range urls {
+		if _, err := c.findResource(rurl); err != nil {
+			return nil, err
+		}
+		if r, ok := c.resources[url]; ok {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+// findEmbedded returns the root resource already loaded, which embeds
+// the resource with given url. returns nil, if there is no such resource.
+func (c *Compiler) findEmbedded(url string) *resource {
+	var found *resource
+	var foundURL string
+	if _, ok := c.resources[url]; ok {
+		return nil
+	}
+	for rurl, r := range c.resources {
+		if r.draft == nil {
+			continue
+		}
+		if r.findResource(url) != nil && (found == nil || rurl < foundURL) {
+			found, foundURL = r, rurl
+		}
+	}
+	return found
+}
+
+// lazyRef returns always valid schema for ref in res, if the document it
+// refers is not available without loading. returns nil otherwise.
+func (c *Compiler) lazyRef(r *resource, res *resource, ref string) *Schema {
+	ref, err := resolveURL(r.baseURL(res.floc), ref)
+	if err != nil {
+		return nil
+	}
+	u, _ := split(ref)
+	if r.findResource(u) != nil || c.findEmbedded(u) != nil {
+		return nil
+	}
+	if _, ok := c.resources[u]; ok {
+		return nil
+	}
+	if _, ok := vocabSchemas[u]; ok || findDraft(u) != nil {
+		return nil
+	}
+	if dr, _ := c.findDeclared(u); dr != nil {
+		return nil
+	}
+	valid := true
+	sch := newSchema(ref, r.draft, valid)
+	sch.Always = &valid
+	return sch
+}
+
+// checkLoad returns error if document at s is not added
+// and c does not allow loading it.
+func (c *Compiler) checkLoad(s string) error {
+	if c.AllowRemoteRefs {
+		return nil
+	}
+	if _, ok := c.resources[s]; ok {
+		return nil
+	}
+	if _, ok := vocabSchemas[s]; ok || findDraft(s) != nil {
+		return nil
+	}
+	if r, _ := c.findDeclared(s); r != nil {
+		return nil
+	}
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		for _, host := range c.AllowedHosts {
+			if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("jsonschema: loading %s is not allowed", s)
+}
+
+// loadURL loads the document at given absolute url, using the loader of c.
+func (c *Compiler) loadURL(url string) (io.ReadCloser, error) {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.Loader != nil {
+		if l, ok := c.Loader.(interface {
+			LoadContext(ctx context.Context, uri string) (io.ReadCloser, error)
+		}); ok {
+			return l.LoadContext(ctx, url)
+		}
+		return c.Loader.Load(url)
+	}
+	if c.LoadURLContext != nil {
+		return c.LoadURLContext(ctx, url)
+	}
+	if c.LoadURL != nil {
+		return c.LoadURL(url)
+	}
+	return LoadURL(url)
+}
+
+// prefetch loads the external documents referred from document at url,
+// transitively, using c.MaxConcurrentLoads workers. Loaded documents are
+// added as resources. Load errors are ignored, they are reported when the
+// document is loaded again during compilation.
+func (c *Compiler) prefetch(url string) {
+	seen := make(map[string]struct{})
+	var pending []string
+	discover := func(url string) {
+		r, ok := c.resources[url]
+		if !ok {
+			return
+		}
+		for _, ref := range externalRefs(url, r.doc) {
+			if _, ok := seen[ref]; ok {
+				continue
+			}
+			seen[ref] = struct{}{}
+			if _, ok := c.resources[ref]; ok {
+				continue
+			}
+			if _, ok := vocabSchemas[ref]; ok || findDraft(ref) != nil {
+				continue
+			}
+			if c.checkLoad(ref) != nil {
+				continue
+			}
+			pending = append(pending, ref)
+		}
+	}
+	seen[url] = struct{}{}
+	if _, ok := c.resources[url]; ok {
+		discover(url)
+	} else {
+		pending = append(pending, url)
+	}
+
+	for len(pending) > 0 {
+		urls := pending
+		pending = nil
+
+		// load urls concurrently
+		docs := make([]interface{}, len(urls))
+		orders := make([]map[string][]string, len(urls))
+		jobs := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < c.MaxConcurrentLoads && w < len(urls); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					r, err := c.loadURL(urls[i])
+					if err != nil {
+						continue
+					}
+					docs[i], orders[i], _ = c.decode(r)
+					_ = r.Close()
+				}
+			}()
+		}
+		for i := range urls {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+
+		for i, url := range urls {
+			if docs[i] == nil {
+				continue
+			}
+			if err := c.addResource(url, docs[i], orders[i]); err == nil {
+				discover(url)
+			}
+		}
+	}
+}
+
+// externalRefs returns the urls of documents referred by $ref in doc at url.
+// urls are returned without fragment, in the order they appear in doc.
+// urls of resources embedded in doc are not returned.
+func externalRefs(url string, doc interface{}) []string {
+	var refs []string
+	ids := map[string]struct{}{url: {}}
+	var walk func(base string, v interface{})
+	walk = func(base string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for _, kw := range []string{"$id", "id"} {
+				if id, ok := v[kw].(string); ok {
+					if u, err := resolveURL(base, id); err == nil {
+						base, _ = split(u)
+						ids[base] = struct{}{}
+					}
+					break
+				}
+			}
+			if ref, ok := v["$ref"].(string); ok {
+				if u, err := resolveURL(base, ref); err == nil {
+					u, _ := split(u)
+					refs = append(refs, u)
+				}
+			}
+			pnames := make([]string, 0, len(v))
+			for pname := range v {
+				switch pname {
+				case "const", "enum", "default", "examples":
+					// json values, not schemas
+				default:
+					pnames = append(pnames, pname)
+				}
+			}
+			sort.Strings(pnames)
+			for _, pname := range pnames {
+				walk(base, v[pname])
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(base, item)
+			}
+		}
+	}
+	walk(url, doc)
+
+	external := refs[:0]
+	for _, ref := range refs {
+		if _, ok := ids[ref]; !ok {
+			external = append(external, ref)
+		}
+	}
+	return external
+}
+
 func (c *Compiler) findResource(url string) (*resource, error) {
+	if _, ok := c.resources[url]; !ok {
+		if r, err := c.findDeclared(url); r != nil || err != nil {
+			return r, err
+		}
+	}
 	if _, ok := c.resources[url]; !ok {
 		// load resource
 		var rdr io.Reader
+		d := findDraft(url)
 		if sch, ok := vocabSchemas[url]; ok {
 			rdr = strings.NewReader(sch)
+		} else if d != nil && d.metaSource != "" {
+			// served offline, so that fragments of draft metaschema can be referred
+			rdr = strings.NewReader(d.metaSource)
 		} else {
-			loadURL := LoadURL
-			if c.LoadURL != nil {
-				loadURL = c.LoadURL
-			}
-			r, err := loadURL(url)
+			r, err := c.loadURL(url)
 			if err != nil {
-				return nil, err
+				return nil, &loadError{url, err}
 			}
 			defer r.Close()
 			rdr = r
@@ -177,6 +785,9 @@ func (c *Compiler) findResource(url string) (*resource, error) {
 		if err := c.AddResource(url, rdr); err != nil {
 			return nil, err
 		}
+		if d != nil {
+			c.resources[url].builtin = true
+		}
 	}
 
 	r := c.resources[url]
@@ -184,8 +795,15 @@ func (c *Compiler) findResource(url string) (*resource, error) {
 		return r, nil
 	}
 
+	if c.MaxSchemaDepth > 0 && depth(r.doc) > c.MaxSchemaDepth {
+		return nil, fmt.Errorf("jsonschema: %s exceeds max schema depth %d", url, c.MaxSchemaDepth)
+	}
+
 	// set draft
 	r.draft = c.Draft
+	if c.OpenAPI {
+		r.draft = Draft2020
+	}
 	if m, ok := r.doc.(map[string]interface{}); ok {
 		if sch, ok := m["$schema"]; ok {
 			sch, ok := sch.(string)
@@ -201,9 +819,12 @@ func (c *Compiler) findResource(url string) (*resource, error) {
 				if sch == url {
 					return nil, fmt.Errorf("jsonschema: unsupported draft in %s", url)
 				}
+				if err := c.checkLoad(sch); err != nil {
+					return nil, err
+				}
 				mr, err := c.findResource(sch)
 				if err != nil {
-					return nil, err
+					return nil, refError(err, "$schema", url+"#")
 				}
 				r.draft = mr.draft
 			}
@@ -217,6 +838,10 @@ func (c *Compiler) findResource(url string) (*resource, error) {
 	}
 	if id != "" {
 		r.url = id
+		// so that it can be referred by declared id
+		if _, ok := c.resources[id]; !ok {
+			c.resources[id] = r
+		}
 	}
 
 	if err := r.fillSubschemas(c, r); err != nil {
@@ -229,12 +854,15 @@ func (c *Compiler) findResource(url string) (*resource, error) {
 }
 
 func (c *Compiler) compileURL(url string, stack []schemaRef, ptr string) (*Schema, error) {
-	// if url points to a draft, return Draft.meta
+	// if url points to a draft, return Draft.meta,
+	// unless the draft url is added as resource
+	b, f := split(url)
 	if d := findDraft(url); d != nil && d.meta != nil {
-		return d.meta, nil
+		if r, ok := c.resources[b]; !ok || r.builtin {
+			return d.meta, nil
+		}
 	}
 
-	b, f := split(url)
 	r, err := c.findResource(b)
 	if err != nil {
 		return nil, err
@@ -252,15 +880,29 @@ func (c *Compiler) compileRef(r *resource, stack []schemaRef, refPtr string, res
 	u, f := split(ref)
 	sr := r.findResource(u)
 	if sr == nil {
+		if rr := c.findEmbedded(u); rr != nil {
+			return c.compileRef(rr, stack, refPtr, rr, ref)
+		}
+
 		// external resource
+		if err := c.checkLoad(u); err != nil {
+			return nil, err
+		}
 		return c.compileURL(ref, stack, refPtr)
 	}
 
 	// ensure root resource is always compiled first.
 	// this is required to get schema.meta from root resource
 	if r.schema == nil {
-		r.schema = newSchema(r.url, r.floc, r.draft, r.doc)
-		if _, err := c.compile(r, nil, schemaRef{"#", r.schema, false}, r); err != nil {
+		r.schema = newSchema(r.url+r.floc, r.draft, r.doc)
+		r.schema.docURL, r.schema.compiler = r.url, c
+		rstack, rref := []schemaRef(nil), schemaRef{"#", "", r.schema, false}
+		if f == "#" {
+			// root is the target of ref. so it is compiled in stack
+			// to detect $ref cycles spanning resources
+			rstack, rref = stack, schemaRef{refPtr, "", r.schema, false}
+		}
+		if _, err := c.compile(r, rstack, rref, r); err != nil {
 			r.schema = nil
 			return nil, err
 		}
@@ -271,24 +913,65 @@ func (c *Compiler) compileRef(r *resource, stack []schemaRef, refPtr string, res
 		return nil, err
 	}
 	if sr == nil {
-		return nil, fmt.Errorf("jsonschema: %s not found", ref)
+		return nil, &fragmentError{u, f}
 	}
 
 	if sr.schema != nil {
-		if err := checkLoop(stack, schemaRef{refPtr, sr.schema, false}); err != nil {
+		if err := checkLoop(stack, schemaRef{refPtr, "", sr.schema, false}); err != nil {
 			return nil, err
 		}
 		return sr.schema, nil
 	}
 
-	sr.schema = newSchema(r.url, sr.floc, r.draft, sr.doc)
-	sch, err := c.compile(r, stack, schemaRef{refPtr, sr.schema, false}, sr)
+	sr.schema = newSchema(r.canonicalURL(sr.floc), r.draft, sr.doc)
+	sr.schema.docURL, sr.schema.compiler = r.url, c
+	sch, err := c.compile(r, stack, schemaRef{refPtr, "", sr.schema, false}, sr)
 	if err != nil {
 		sr.schema = nil
 	}
 	return sch, err
 }
 
+// loadError tells that document at url could not be loaded.
+type loadError struct {
+	url string
+	err error
+}
+
+func (e *loadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *loadError) Unwrap() error {
+	return e.err
+}
+
+// fragmentError tells that json-pointer or anchor f is not found
+// in resource at url.
+type fragmentError struct {
+	url string
+	f   string
+}
+
+func (e *fragmentError) Error() string {
+	if strings.HasPrefix(e.f, "#/") {
+		return fmt.Sprintf("jsonschema: json-pointer %q not found in %s", e.f[1:], e.url)
+	}
+	return fmt.Sprintf("jsonschema: anchor %q not found in %s", e.f[1:], e.url)
+}
+
+// refError returns err with the location of keyword kw, if err tells that
+// the ref of kw could not be resolved. other errors are returned as is.
+func refError(err error, kw, loc string) error {
+	switch e := err.(type) {
+	case *loadError:
+		return fmt.Errorf("jsonschema: cannot load %s, referred by %s in %s: %w", e.url, kw, loc, e.err)
+	case *fragmentError:
+		return fmt.Errorf("%v, referred by %s in %s", e, kw, loc)
+	}
+	return err
+}
+
 func (c *Compiler) compileDynamicAnchors(r *resource, res *resource) error {
 	if r.draft.version < 2020 {
 		return nil
@@ -314,6 +997,11 @@ func (c *Compiler) compile(r *resource, stack []schemaRef, sref schemaRef, res *
 	if err := c.compileDynamicAnchors(r, res); err != nil {
 		return nil, err
 	}
+	res.schema.translator = c.Translator
+	res.schema.maxDepth = c.MaxValidationDepth
+	res.schema.marshalUnknown = c.MarshalUnknownTypes
+	res.schema.useFloat = !c.UseNumber
+	res.schema.trackPositions = c.TrackPositions
 
 	switch v := res.doc.(type) {
 	case bool:
@@ -335,6 +1023,30 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 	var s = res.schema
 	var err error
 
+	compileRef := func(kw string, ref string) (*Schema, error) {
+		c.refDepth++
+		defer func() { c.refDepth-- }()
+		if c.MaxRefDepth > 0 && c.refDepth > c.MaxRefDepth {
+			return nil, fmt.Errorf("jsonschema: %s in %s exceeds max $ref depth %d", kw, r.url+res.floc, c.MaxRefDepth)
+		}
+		if c.LazyRefs {
+			if sch := c.lazyRef(r, res, ref); sch != nil {
+				return sch, nil
+			}
+		}
+		sch, err := c.compileRef(r, stack, kw, res, ref)
+		return sch, refError(err, kw, r.url+res.floc)
+	}
+
+	if c.Strict {
+		if err := c.checkKeywords(r, res, m); err != nil {
+			return err
+		}
+	}
+	if c.Lint {
+		c.lint(r, res, m)
+	}
+
 	if r == res { // root schema
 		if sch, ok := m["$schema"]; ok {
 			sch := sch.(string)
@@ -342,14 +1054,17 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 				s.meta = d.meta
 			} else {
 				if s.meta, err = c.compileRef(r, stack, "$schema", res, sch); err != nil {
-					return err
+					return refError(err, "$schema", r.url+res.floc)
 				}
 			}
+		} else {
+			// vocabularies are taken from draft's metaschema
+			s.meta = r.draft.meta
 		}
 	}
 
 	if ref, ok := m["$ref"]; ok {
-		s.Ref, err = c.compileRef(r, stack, "$ref", res, ref.(string))
+		s.Ref, err = compileRef("$ref", ref.(string))
 		if err != nil {
 			return err
 		}
@@ -366,7 +1081,7 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 					if reqd, ok := reqd.(bool); ok && !reqd {
 						continue
 					}
-					if !r.draft.isVocab(url) {
+					if !r.draft.isVocab(url) && url != openAPIVocab {
 						return fmt.Errorf("jsonschema: unsupported vocab %q in %s", url, res)
 					}
 					s.vocab = append(s.vocab, url)
@@ -377,7 +1092,7 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 		}
 
 		if ref, ok := m["$recursiveRef"]; ok {
-			s.RecursiveRef, err = c.compileRef(r, stack, "$recursiveRef", res, ref.(string))
+			s.RecursiveRef, err = compileRef("$recursiveRef", ref.(string))
 			if err != nil {
 				return err
 			}
@@ -385,7 +1100,7 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 	}
 	if r.draft.version >= 2020 {
 		if dref, ok := m["$dynamicRef"]; ok {
-			s.DynamicRef, err = c.compileRef(r, stack, "$dynamicRef", res, dref.(string))
+			s.DynamicRef, err = compileRef("$dynamicRef", dref.(string))
 			if err != nil {
 				return err
 			}
@@ -424,6 +1139,15 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 				s.Types = toStrings(t)
 			}
 		}
+		if nullable, ok := m["nullable"].(bool); ok && nullable && c.TreatNullableKeyword && len(s.Types) > 0 {
+			hasNull := false
+			for _, t := range s.Types {
+				hasNull = hasNull || t == "null"
+			}
+			if !hasNull {
+				s.Types = append(s.Types[:len(s.Types):len(s.Types)], "null")
+			}
+		}
 
 		if e, ok := m["enum"]; ok {
 			s.Enum = e.([]interface{})
@@ -469,7 +1193,7 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 
 		if pattern, ok := m["pattern"]; ok {
 			var err error
-			s.Pattern, err = c.CompileRegex(pattern.(string))
+			s.Pattern, err = c.compileRegex(pattern.(string))
 			if err != nil {
 				panic("regex Format and compiler.CompileRegex are incompatible")
 			}
@@ -541,6 +1265,13 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 					return err
 				}
 			}
+			if c.PreserveOrder {
+				s.PropertyOrder = r.order[res.floc[1:]+"/properties"]
+				if len(s.PropertyOrder) != len(props) {
+					// order not known
+					s.PropertyOrder = sortedKeys(s.Properties)
+				}
+			}
 		}
 
 		if regexProps, ok := m["regexProperties"]; ok {
@@ -551,7 +1282,11 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 			patternProps := patternProps.(map[string]interface{})
 			s.PatternProperties = make(map[Regexp]*Schema, len(patternProps))
 			for pattern := range patternProps {
-				s.PatternProperties[regexp.MustCompile(pattern)], err = compile(nil, "patternProperties/"+escape(pattern))
+				re, err := c.compileRegex(pattern)
+				if err != nil {
+					panic("regex Format and compiler.CompileRegex are incompatible")
+				}
+				s.PatternProperties[re], err = compile(nil, "patternProperties/"+escape(pattern))
 				if err != nil {
 					return err
 				}
@@ -672,17 +1407,49 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 		}
 	}
 
-	if format, ok := m["format"]; ok {
+	if format, ok := m["format"]; ok && c.FormatMode != FormatDisable {
 		s.Format = format.(string)
-		if r.draft.version < 2019 || c.AssertFormat || r.schema.meta.hasVocab("format-assertion") {
-			if format, ok := c.Formats[s.Format]; ok {
+		var assert bool
+		switch c.FormatMode {
+		case FormatIgnore:
+			assert = false
+		case FormatWarn, FormatAssert:
+			assert = true
+		default:
+			assert = r.draft.version < 2019 || c.AssertFormat || r.schema.meta.hasVocab("format-assertion")
+		}
+		s.formatWarn = c.FormatMode == FormatWarn
+		if assert {
+			if format, ok := c.FormatsWithDetail[s.Format]; ok {
+				s.formatDetail = format
+			} else if format, ok := c.Formats[s.Format]; ok {
 				s.format = format
+			} else if format, ok := FormatsWithDetail[s.Format]; ok {
+				s.formatDetail = format
 			} else {
 				s.format = Formats[s.Format]
 			}
 		}
 	}
 
+	if em, ok := m["errorMessage"]; ok {
+		switch em := em.(type) {
+		case string:
+			s.ErrorMessage = &ErrorMessage{Text: em}
+		case map[string]interface{}:
+			s.ErrorMessage = &ErrorMessage{Keywords: make(map[string]string, len(em))}
+			for kw, text := range em {
+				text, ok := text.(string)
+				if !ok {
+					return fmt.Errorf("jsonschema: errorMessage of %q must be string in %s", kw, r.url+res.floc)
+				}
+				s.ErrorMessage.Keywords[kw] = text
+			}
+		default:
+			return fmt.Errorf("jsonschema: errorMessage must be string or object in %s", r.url+res.floc)
+		}
+	}
+
 	if c.ExtractAnnotations {
 		if title, ok := m["title"]; ok {
 			s.Title = title.(string)
@@ -697,6 +1464,14 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 		if c, ok := m["const"]; ok {
 			s.Constant = []interface{}{c}
 		}
+		if c.ExtractAnnotations {
+			if examples, ok := m["examples"]; ok {
+				s.Examples = examples.([]interface{})
+			}
+			if example, ok := m["example"]; ok && c.openAPI(r) {
+				s.Examples = append(s.Examples[:len(s.Examples):len(s.Examples)], example)
+			}
+		}
 	}
 
 	if r.draft.version >= 7 {
@@ -729,9 +1504,6 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 			if writeOnly, ok := m["writeOnly"]; ok {
 				s.WriteOnly = writeOnly.(bool)
 			}
-			if examples, ok := m["examples"]; ok {
-				s.Examples = examples.([]interface{})
-			}
 		}
 	}
 
@@ -749,6 +1521,13 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 	}
 
 	for name, ext := range c.extensions {
+		// ext.meta describes only the keywords of a single schema,
+		// so it is validated against each subschema being compiled
+		if ext.meta != nil {
+			if _, err := ext.meta.validateWith(&validator{formats: c.Formats}, m, res.floc[1:]); err != nil {
+				return err
+			}
+		}
 		es, err := ext.compiler.Compile(CompilerContext{c, r, stack, res}, m)
 		if err != nil {
 			return err
@@ -764,23 +1543,109 @@ func (c *Compiler) compileMap(r *resource, stack []schemaRef, sref schemaRef, re
 	return nil
 }
 
-func (c *Compiler) validateSchema(r *resource, v interface{}, vloc string) error {
-	validate := func(meta *Schema) error {
-		if meta == nil {
-			return nil
+// FormatMode tells how "format" keyword is validated.
+type FormatMode int
+
+const (
+	// FormatDefault asserts format in draft7 and earlier. In later drafts,
+	// format is asserted only if Compiler.AssertFormat is true or the
+	// metaschema uses format-assertion vocabulary.
+	FormatDefault FormatMode = iota
+
+	// FormatIgnore never asserts format. format is only an annotation.
+	FormatIgnore
+
+	// FormatWarn validates format, but violations do not fail validation.
+	// They are reported as Result.Warnings by Schema.ValidateWithResult.
+	FormatWarn
+
+	// FormatAssert always asserts format.
+	FormatAssert
+
+	// FormatDisable skips format keyword entirely. It is neither asserted
+	// nor retained in Schema.Format.
+	FormatDisable
+)
+
+// compileRegex is like c.CompileRegex, but returns cached Regexp
+// if pattern is already compiled.
+func (c *Compiler) compileRegex(pattern string) (Regexp, error) {
+	if re, ok := c.regexes[pattern]; ok {
+		return re, nil
+	}
+	re, err := c.CompileRegex(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if c.regexes == nil {
+		c.regexes = make(map[string]Regexp)
+	}
+	c.regexes[pattern] = re
+	return re, nil
+}
+
+// checkKeywords returns error if schema m at res has keyword unknown to r.draft.
+func (c *Compiler) checkKeywords(r *resource, res *resource, m map[string]interface{}) error {
+	var unknown []string
+outer:
+	for kw := range m {
+		if _, ok := r.draft.keywords[kw]; ok || kw == "errorMessage" || strings.HasPrefix(kw, "x-") {
+			continue
+		}
+		if _, ok := openAPIKeywords[kw]; ok && c.openAPI(r) {
+			continue
+		}
+		if kw == "nullable" && c.TreatNullableKeyword {
+			continue
+		}
+		for _, ext := range c.extensions {
+			if ext.meta == nil {
+				continue
+			}
+			if _, ok := ext.meta.Properties[kw]; ok {
+				continue outer
+			}
 		}
-		return meta.validateValue(v, vloc)
+		unknown = append(unknown, kw)
 	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	for i, kw := range unknown {
+		unknown[i] = strconv.Quote(kw)
+	}
+	return fmt.Errorf("jsonschema: unknown keyword %s in %s", strings.Join(unknown, ", "), r.url+res.floc)
+}
 
-	if err := validate(r.draft.meta); err != nil {
-		return err
+func (c *Compiler) validateSchema(r *resource, v interface{}, vloc string) error {
+	if r.draft.meta == nil {
+		return nil
 	}
-	for _, ext := range c.extensions {
-		if err := validate(ext.meta); err != nil {
-			return err
+	// extension metaschemas are validated against each subschema in compileMap
+	_, err := r.draft.meta.validateWith(&validator{formats: c.Formats}, v, vloc)
+	return err
+}
+
+// depth returns the nesting depth of json value v.
+// scalars have depth 1.
+func depth(v interface{}) int {
+	d := 0
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, pvalue := range v {
+			if pd := depth(pvalue); pd > d {
+				d = pd
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if id := depth(item); id > d {
+				d = id
+			}
 		}
 	}
-	return nil
+	return d + 1
 }
 
 func toStrings(arr []interface{}) []string {
@@ -794,12 +1659,22 @@ func toStrings(arr []interface{}) []string {
 // SchemaRef captures schema and the path referring to it.
 type schemaRef struct {
 	path    string  // relative-json-pointer to schema
+	tok     string  // unescaped token appended to path. empty if none
 	schema  *Schema // target schema
 	discard bool    // true when scope left
 }
 
+// ptr returns the relative-json-pointer to schema, escaped for use
+// in uri fragment.
+func (sr schemaRef) ptr() string {
+	if sr.tok == "" {
+		return sr.path
+	}
+	return sr.path + "/" + escape(sr.tok)
+}
+
 func (sr schemaRef) String() string {
-	return fmt.Sprintf("(%s)%v", sr.path, sr.schema)
+	return fmt.Sprintf("(%s)%v", sr.ptr(), sr.schema)
 }
 
 func checkLoop(stack []schemaRef, sref schemaRef) error {
@@ -814,11 +1689,15 @@ func checkLoop(stack []schemaRef, sref schemaRef) error {
 func keywordLocation(stack []schemaRef, path string) string {
 	var loc string
 	for _, ref := range stack[1:] {
-		loc += "/" + ref.path
+		loc += "/" + ref.ptr()
 	}
 	if path != "" {
 		loc = loc + "/" + path
 	}
+	// keyword paths are escaped for use in uri fragment
+	if u, err := url.PathUnescape(loc); err == nil {
+		loc = u
+	}
 	return loc
 }
 
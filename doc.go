@@ -11,7 +11,11 @@ Features:
   - detects infinite loop in schemas
   - thread safe validation
   - rich, intuitive hierarchial error messages with json-pointers to exact location
+  - custom error messages via errorMessage keyword
   - supports output formats flag, basic and detailed
+  - collects annotations produced during validation
+  - reports the matched subschemas of oneOf and anyOf
+  - fills missing properties with their defaults
   - supports enabling format and content Assertions in draft2019-09 or above
   - change Compiler.AssertFormat, Compiler.AssertContent to true
   - compiled schema can be introspected. easier to develop tools like generating go structs given schema
@@ -20,14 +24,14 @@ Features:
   - date-time, date, time, duration (supports leap-second)
   - uuid, hostname, email
   - ip-address, ipv4, ipv6
-  - uri, uriref, uri-template(limited validation)
+  - uri, uriref, iri, iri-reference, uri-template
   - json-pointer, relative-json-pointer
   - regex, format
   - implements following contentEncoding (supports user-defined)
   - base64
   - implements following contentMediaType (supports user-defined)
   - application/json
-  - can load from files/http/https/string/[]byte/io.Reader (supports user-defined)
+  - can load from files/http/https/string/[]byte/io.Reader/fs.FS (supports user-defined)
 
 The schema is compiled against the version specified in "$schema" property.
 If "$schema" property is missing, it uses latest draft which currently implemented
@@ -42,7 +46,7 @@ This package supports loading json-schema from filePath and fileURL.
 
 To load json-schema from HTTPURL, add following import:
 
-	import _ "github.com/santhosh-tekuri/jsonschema/v5/httploader"
+	import _ "gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6/httploader"
 
 you can validate yaml documents. see https://play.golang.org/p/sJy1qY7dXgA
 */
@@ -15,6 +15,8 @@ type Draft struct {
 	vocab        []string // built-in vocab
 	defaultVocab []string // vocabs when $vocabulary is not used
 	subschemas   map[string]position
+	keywords     map[string]struct{} // keywords known by meta. used by Compiler.Strict
+	metaSource   string              // json document of meta. served when its url is loaded
 }
 
 func (d *Draft) URL() string {
@@ -45,6 +47,24 @@ func (d *Draft) loadMeta(url, schema string) {
 	}
 	d.meta = c.MustCompile(url)
 	d.meta.meta = d.meta
+	d.metaSource = schema
+	d.keywords = map[string]struct{}{"$ref": {}} // draft4 metaschema does not list $ref
+	collectKeywords(d.meta, d.keywords)
+}
+
+// collectKeywords collects properties of metaschema s and of the
+// metaschemas it refers in allOf or $ref, into kws.
+func collectKeywords(s *Schema, kws map[string]struct{}) {
+	if s == nil {
+		return
+	}
+	for pname := range s.Properties {
+		kws[pname] = struct{}{}
+	}
+	collectKeywords(s.Ref, kws)
+	for _, sch := range s.AllOf {
+		collectKeywords(sch, kws)
+	}
 }
 
 func (d *Draft) getID(sch interface{}) string {
@@ -503,6 +523,10 @@ func init() {
 				"type": "string"
 			},
 			"default": {},
+			"examples": {
+				"type": "array",
+				"items": {}
+			},
 			"multipleOf": {
 				"type": "number",
 				"exclusiveMinimum": 0
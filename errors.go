@@ -29,10 +29,38 @@ func infiniteLoopError(stack []schemaRef, sref schemaRef) InfiniteLoopError {
 		if path == "" {
 			path += ref.schema.Location
 		} else {
-			path += "/" + ref.path
+			path += "/" + ref.ptr()
 		}
 	}
-	return InfiniteLoopError(path + "/" + sref.path)
+	return InfiniteLoopError(path + "/" + sref.ptr())
+}
+
+// DepthError is returned by Validate, when nesting of schemas applied
+// exceeds Compiler.MaxValidationDepth. this gives keywordLocation
+// at which it is exceeded.
+type DepthError string
+
+func (e DepthError) Error() string {
+	return "jsonschema: max validation depth exceeded at " + string(e)
+}
+
+// RecordError is the error type sent by ValidateStream.
+// It tells the line of the document that is not valid.
+type RecordError struct {
+	// Line is the line number of document, starting at 1.
+	Line int
+
+	// Err is the error that occurred for the document. It is
+	// *ValidationError, if document is valid json.
+	Err error
+}
+
+func (re *RecordError) Unwrap() error {
+	return re.Err
+}
+
+func (re *RecordError) Error() string {
+	return fmt.Sprintf("jsonschema: line %d: %v", re.Line, strings.TrimPrefix(re.Err.Error(), "jsonschema: "))
 }
 
 // SchemaError is the error type returned by Compile.
@@ -71,8 +99,39 @@ type ValidationError struct {
 	KeywordLocation         string             // validation path of validating keyword or schema
 	AbsoluteKeywordLocation string             // absolute location of validating keyword or schema
 	InstanceLocation        string             // location of the json value within the instance being validated
+	InstanceValue           interface{}        // json value at InstanceLocation. objects and arrays are not copied, but refer to the instance
 	Message                 fmt.Stringer       // captures the message and data used in constructing it
 	Causes                  []*ValidationError // nested validation errors
+	Position                *Position          // position of InstanceLocation in the document, if Compiler.TrackPositions is set. nil if unknown
+	Truncated               bool               // whether errors may have been omitted by Schema.ValidateN. set only on the root error
+	translator              Translator         // Compiler.Translator of schema that reported this error
+}
+
+// Translator returns the message for given message key and args, in the language of choice.
+// If it returns empty string, the default english message is used.
+//
+// See msg.Key and msg.Args for the key and args of a message.
+type Translator func(key string, args map[string]interface{}) string
+
+// MessageKey returns the stable key of Message. For example "minLength".
+func (ve *ValidationError) MessageKey() string {
+	return msg.Key(ve.Message)
+}
+
+// MessageArgs returns the structured arguments of Message. For example
+// "got" and "want" for "minLength".
+func (ve *ValidationError) MessageArgs() map[string]interface{} {
+	return msg.Args(ve.Message)
+}
+
+// message returns Message, translated if Translator is used.
+func (ve *ValidationError) message() string {
+	if ve.translator != nil {
+		if s := ve.translator(msg.Key(ve.Message), msg.Args(ve.Message)); s != "" {
+			return s
+		}
+	}
+	return ve.Message.String()
 }
 
 func (ve *ValidationError) add(causes ...error) error {
@@ -93,18 +152,149 @@ func (ve *ValidationError) causes(err error) error {
 }
 
 func (ve *ValidationError) Error() string {
+	if _, ok := ve.Message.(msg.Schemas); ok && len(ve.Causes) > 0 {
+		// reported by ValidateAll, not by any schema
+		return fmt.Sprintf("jsonschema: %s: %s", ve.message(), strings.TrimPrefix(ve.Causes[0].Error(), "jsonschema: "))
+	}
 	leaf := ve
 	for len(leaf.Causes) > 0 {
 		leaf = leaf.Causes[0]
 	}
 	u, _ := split(ve.AbsoluteKeywordLocation)
-	return fmt.Sprintf("jsonschema: %s does not validate with %s: %s", quote(leaf.InstanceLocation), u+"#"+leaf.KeywordLocation, leaf.Message)
+	return fmt.Sprintf("jsonschema: %s does not validate with %s: %s", quote(leaf.InstanceLocation), u+"#"+leaf.KeywordLocation, leaf.message())
+}
+
+// ErrorMessage captures custom error messages specified by 'errorMessage' keyword.
+//
+// If errorMessage is string, all failures of the schema are replaced by
+// single error with that message. If errorMessage is object, its keys are
+// keywords of the schema, and the error of a failing keyword is replaced by
+// error with the corresponding message. Causes of replaced errors are dropped.
+type ErrorMessage struct {
+	Text     string            // message for any failure. empty if errorMessage is object.
+	Keywords map[string]string // message for failure of keyword.
+}
+
+// apply replaces errors in ve with custom messages.
+// ve is the error returned by schema at keyword location kloc.
+func (em *ErrorMessage) apply(ve *ValidationError, kloc string, validationError func(string, fmt.Stringer) *ValidationError) error {
+	if em.Text != "" {
+		return validationError("errorMessage", msg.Custom{Text: em.Text})
+	}
+	errors := []*ValidationError{ve}
+	if _, ok := ve.Message.(msg.Empty); ok && ve.KeywordLocation == kloc {
+		errors = ve.Causes
+	}
+	for _, e := range errors {
+		kw := strings.TrimPrefix(e.KeywordLocation, kloc+"/")
+		if i := strings.IndexByte(kw, '/'); i != -1 {
+			kw = kw[:i]
+		}
+		if text, ok := em.Keywords[kw]; ok {
+			e.Message = msg.Custom{Text: text}
+			e.Causes = nil
+		}
+	}
+	return ve
+}
+
+// LeafErrors returns the errors without causes in this error tree, in depth-first order.
+// i.e the individual keywords that failed. Errors with same instance location and
+// keyword location are reported once.
+//
+// Note that for anyOf and oneOf, leaf errors of all failed subschemas are returned.
+func (ve *ValidationError) LeafErrors() []*ValidationError {
+	type key struct{ vloc, kloc string }
+	var leaves []*ValidationError
+	seen := make(map[key]struct{})
+	var collect func(*ValidationError)
+	collect = func(ve *ValidationError) {
+		if len(ve.Causes) == 0 {
+			k := key{ve.InstanceLocation, ve.KeywordLocation}
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				leaves = append(leaves, ve)
+			}
+			return
+		}
+		for _, cause := range ve.Causes {
+			collect(cause)
+		}
+	}
+	collect(ve)
+	return leaves
+}
+
+// FieldErrors returns the messages of leaf errors, keyed by their instance location.
+// If multiple leaf errors have same instance location, the message of the one
+// with deepest keyword location is retained; on tie the first in depth-first order.
+//
+// For 'required', message for each missing property is keyed by the location of
+// that property rather than the object.
+func (ve *ValidationError) FieldErrors() map[string]string {
+	fields := make(map[string]string)
+	depths := make(map[string]int)
+	set := func(vloc string, e *ValidationError) {
+		depth := strings.Count(e.KeywordLocation, "/")
+		if d, ok := depths[vloc]; ok && d >= depth {
+			return
+		}
+		fields[vloc], depths[vloc] = e.message(), depth
+	}
+	for _, leaf := range ve.LeafErrors() {
+		if m, ok := leaf.Message.(msg.Required); ok {
+			for _, pname := range m.Want {
+				e := *leaf
+				e.Message = msg.Required{Want: []string{pname}}
+				set(leaf.InstanceLocation+"/"+escapePtr(pname), &e)
+			}
+			continue
+		}
+		set(leaf.InstanceLocation, leaf)
+	}
+	return fields
+}
+
+// truncate removes leaf errors from ve beyond the first *n, along with
+// causes left without leaves, decrementing *n for each leaf kept.
+// reports whether any error is removed.
+func (ve *ValidationError) truncate(n *int) bool {
+	truncated := false
+	causes := ve.Causes[:0]
+	for _, cause := range ve.Causes {
+		switch {
+		case *n == 0:
+			truncated = true
+		case len(cause.Causes) == 0:
+			*n--
+			causes = append(causes, cause)
+		default:
+			if cause.truncate(n) {
+				truncated = true
+			}
+			causes = append(causes, cause)
+		}
+	}
+	ve.Causes = causes
+	return truncated
+}
+
+// leaves returns the number of leaf errors in ve.
+func (ve *ValidationError) leaves() int {
+	if len(ve.Causes) == 0 {
+		return 1
+	}
+	n := 0
+	for _, cause := range ve.Causes {
+		n += cause.leaves()
+	}
+	return n
 }
 
 func (ve *ValidationError) GoString() string {
 	sloc := ve.AbsoluteKeywordLocation
 	sloc = sloc[strings.IndexByte(sloc, '#')+1:]
-	msg := fmt.Sprintf("[I#%s] [S#%s] %s", ve.InstanceLocation, sloc, ve.Message)
+	msg := fmt.Sprintf("[I#%s] [S#%s] %s", ve.InstanceLocation, sloc, ve.message())
 	for _, c := range ve.Causes {
 		for _, line := range strings.Split(c.GoString(), "\n") {
 			msg += "\n  " + line
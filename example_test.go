@@ -11,7 +11,7 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6"
 )
 
 func Example() {
@@ -116,3 +116,53 @@ func (ctx ValidationContext) Error(keywordPath string, msg fmt.Stringer) *Valida
 func (ValidationError) Group(parent *ValidationError, causes ...error) error {
 	return parent.add(causes...)
 }
+
+// validateExtensions validates v with the extensions of s, merging their
+// results into result. scope and vscope are as in s.validate, including s.
+//
+// It is separate from s.validate, so that the closures escaping into
+// ValidationContext do not cause heap allocation for schemas without extensions.
+func (s *Schema) validateExtensions(vd *validator, scope []schemaRef, vscope int, v interface{}, result *validationResult) []error {
+	validationError := func(keywordPath string, msg fmt.Stringer) *ValidationError {
+		return &ValidationError{
+			KeywordLocation:         keywordLocation(scope, keywordPath),
+			AbsoluteKeywordLocation: joinPtr(s.Location, keywordPath),
+			InstanceLocation:        vd.vloc(),
+			InstanceValue:           v,
+			Message:                 msg,
+			translator:              s.translator,
+		}
+	}
+	validate := func(sch *Schema, schPath string, v interface{}, vpath string) error {
+		if vd.ctx != nil {
+			if err := vd.ctx.Err(); err != nil {
+				panic(contextError{err})
+			}
+		}
+		n := vd.push(vpath)
+		uneval := vd.uneval
+		vd.uneval = 0
+		vr, err := sch.validate(vd, scope, 0, schPath, "", v)
+		vd.uneval = uneval
+		vd.loc = vd.loc[:n]
+		if err == nil {
+			result.merge(vr)
+		}
+		return err
+	}
+	validateInplace := func(sch *Schema, schPath string) error {
+		vr, err := sch.validate(vd, scope, vscope, schPath, "", v)
+		if err == nil {
+			result.mergeInplace(vr)
+		}
+		return err
+	}
+
+	var errors []error
+	for _, ext := range s.Extensions {
+		if err := ext.Validate(ValidationContext{*result, validate, validateInplace, validationError}, v); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return errors
+}
@@ -47,3 +47,50 @@ func TestPowerOfExt(t *testing.T) {
 		})
 	})
 }
+
+func TestPowerOfExtNested(t *testing.T) {
+	t.Run("invalidSchema", func(t *testing.T) {
+		c := jsonschema.NewCompiler()
+		c.RegisterExtension("powerOf", powerOfMeta, powerOfCompiler{})
+		if err := c.AddResource("test.json", strings.NewReader(`{"properties": {"a": {"powerOf": "hello"}}}`)); err != nil {
+			t.Fatal(err)
+		}
+		_, err := c.Compile("test.json")
+		if err == nil {
+			t.Fatal("error expected")
+		}
+		t.Log(err)
+	})
+	t.Run("validSchema", func(t *testing.T) {
+		c := jsonschema.NewCompiler()
+		c.RegisterExtension("powerOf", powerOfMeta, powerOfCompiler{})
+		if err := c.AddResource("test.json", strings.NewReader(`{"properties": {"a": {"allOf": [{"powerOf": 10}]}}}`)); err != nil {
+			t.Fatal(err)
+		}
+		sch, err := c.Compile("test.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run("validInstance", func(t *testing.T) {
+			if err := sch.Validate(map[string]interface{}{"a": 100}); err != nil {
+				t.Fatal(err)
+			}
+		})
+		t.Run("invalidInstance", func(t *testing.T) {
+			err := sch.Validate(map[string]interface{}{"a": 111})
+			if err == nil {
+				t.Fatal("validation must fail")
+			}
+			leaf := leafError(err.(*jsonschema.ValidationError))
+			if got, want := leaf.KeywordLocation, "/properties/a/allOf/0/powerOf"; got != want {
+				t.Errorf("keywordLocation: got %q, want %q", got, want)
+			}
+			if got, want := leaf.InstanceLocation, "/a"; got != want {
+				t.Errorf("instanceLocation: got %q, want %q", got, want)
+			}
+			if got, want := leaf.Message.String(), "111 not powerOf 10"; got != want {
+				t.Errorf("message: got %q, want %q", got, want)
+			}
+		})
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // Formats is a registry of functions, which know how to validate
@@ -28,10 +29,10 @@ var Formats = map[string]func(interface{}) bool{
 	"ipv4":                  isIPV4,
 	"ipv6":                  isIPV6,
 	"uri":                   isURI,
-	"iri":                   isURI,
+	"iri":                   isIRI,
 	"uri-reference":         isURIReference,
 	"uriref":                isURIReference,
-	"iri-reference":         isURIReference,
+	"iri-reference":         isIRIReference,
 	"uri-template":          isURITemplate,
 	"regex":                 isRegex,
 	"json-pointer":          isJSONPointer,
@@ -39,6 +40,15 @@ var Formats = map[string]func(interface{}) bool{
 	"uuid":                  isUUID,
 }
 
+// FormatsWithDetail is a registry of functions, which know how to validate
+// a specific format, and tell why a value is not valid.
+//
+// It is like Formats, but function returns error describing the failure,
+// or nil if the value is valid. The error text is reported as
+// msg.Format.Detail. A format found here takes precedence over the same
+// format in Formats.
+var FormatsWithDetail = map[string]func(interface{}) error{}
+
 // isDateTime tells whether given string is a valid date representation
 // as defined by RFC 3339, section 5.6.
 //
@@ -426,36 +436,178 @@ func isURIReference(v interface{}) bool {
 	return err == nil && !strings.Contains(s, `\`)
 }
 
+// isIRI tells whether given string is valid IRI, according to RFC 3987.
+func isIRI(v interface{}) bool {
+	s, ok := v.(string)
+	if !ok {
+		return true
+	}
+	if !isIRIChars(s) {
+		return false
+	}
+	u, err := urlParse(s)
+	return err == nil && u.IsAbs()
+}
+
+// isIRIReference tells whether given string is a valid IRI Reference
+// (either an IRI or a relative-reference), according to RFC 3987.
+func isIRIReference(v interface{}) bool {
+	s, ok := v.(string)
+	if !ok {
+		return true
+	}
+	if !isIRIChars(s) {
+		return false
+	}
+	_, err := urlParse(s)
+	return err == nil
+}
+
+// isIRIChars tells whether given string contains only characters allowed
+// in an IRI. i.e. unreserved, reserved, pct-encoded, ucschar and
+// iprivate, where iprivate is allowed only in iquery.
+//
+// see https://datatracker.ietf.org/doc/html/rfc3987#section-2.2, for details
+func isIRIChars(s string) bool {
+	query := strings.IndexByte(s, '?')
+	if hash := strings.IndexByte(s, '#'); hash != -1 && hash < query {
+		query = -1
+	}
+	inQuery := false
+	for i, r := range s {
+		switch {
+		case i == query:
+			inQuery = true
+		case r == '#':
+			inQuery = false
+		case r == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return false
+			}
+		case r < 0x80:
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-._~:/?#[]@!$&'()*+,;=", r)) {
+				return false
+			}
+		case isUCSChar(r):
+		case isIPrivate(r):
+			if !inQuery {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+// isUCSChar tells whether r is ucschar production of RFC 3987.
+func isUCSChar(r rune) bool {
+	switch {
+	case r >= 0xA0 && r <= 0xD7FF, r >= 0xF900 && r <= 0xFDCF, r >= 0xFDF0 && r <= 0xFFEF:
+		return true
+	case r >= 0x10000 && r <= 0xEFFFD:
+		// %x10000-1FFFD / %x20000-2FFFD / ... / %xE1000-EFFFD
+		if r&0xFFFF > 0xFFFD {
+			return false
+		}
+		return r < 0xE0000 || r >= 0xE1000
+	}
+	return false
+}
+
+// isIPrivate tells whether r is iprivate production of RFC 3987.
+func isIPrivate(r rune) bool {
+	return (r >= 0xE000 && r <= 0xF8FF) || (r >= 0xF0000 && r <= 0xFFFFD) || (r >= 0x100000 && r <= 0x10FFFD)
+}
+
 // isURITemplate tells whether given string is a valid URI Template
 // according to RFC6570.
 //
-// Current implementation does minimal validation.
+// see https://datatracker.ietf.org/doc/html/rfc6570#section-2, for details
 func isURITemplate(v interface{}) bool {
 	s, ok := v.(string)
 	if !ok {
 		return true
 	}
-	u, err := urlParse(s)
-	if err != nil {
-		return false
-	}
-	for _, item := range strings.Split(u.RawPath, "/") {
-		depth := 0
-		for _, ch := range item {
-			switch ch {
-			case '{':
-				depth++
-				if depth != 1 {
+	for len(s) > 0 {
+		switch s[0] {
+		case '{':
+			end := strings.IndexByte(s, '}')
+			if end == -1 || !isURITemplateExpr(s[1:end]) {
+				return false
+			}
+			s = s[end+1:]
+		case '%':
+			if len(s) < 3 || !isHex(s[1]) || !isHex(s[2]) {
+				return false
+			}
+			s = s[3:]
+		default:
+			r, size := utf8.DecodeRuneInString(s)
+			if r < 0x80 {
+				if r <= ' ' || strings.ContainsRune("\"'<>\\^`{|}\x7f", r) {
 					return false
 				}
-			case '}':
-				depth--
-				if depth != 0 {
+			} else if !isUCSChar(r) && !isIPrivate(r) {
+				return false
+			}
+			s = s[size:]
+		}
+	}
+	return true
+}
+
+// isURITemplateExpr tells whether given string is valid expression
+// of URI Template, excluding the enclosing braces.
+func isURITemplateExpr(s string) bool {
+	if s == "" {
+		return false
+	}
+	if strings.IndexByte("+#./;?&", s[0]) != -1 {
+		s = s[1:]
+	}
+	for _, varspec := range strings.Split(s, ",") {
+		name := varspec
+		if strings.HasSuffix(name, "*") {
+			name = name[:len(name)-1]
+		} else if colon := strings.IndexByte(name, ':'); colon != -1 {
+			maxLength := name[colon+1:]
+			if len(maxLength) == 0 || len(maxLength) > 4 || maxLength[0] == '0' {
+				return false
+			}
+			for i := 0; i < len(maxLength); i++ {
+				if maxLength[i] < '0' || maxLength[i] > '9' {
 					return false
 				}
 			}
+			name = name[:colon]
 		}
-		if depth != 0 {
+		if !isURITemplateVarName(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// isURITemplateVarName tells whether given string is valid varname
+// of URI Template.
+func isURITemplateVarName(s string) bool {
+	if s == "" || s[0] == '.' || s[len(s)-1] == '.' || strings.Contains(s, "..") {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '.':
+		case c == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return false
+			}
+			i += 2
+		default:
 			return false
 		}
 	}
@@ -465,7 +617,11 @@ func isURITemplate(v interface{}) bool {
 // isRegex tells whether given string is a valid regular expression,
 // according to the ECMA 262 regular expression dialect.
 //
-// The implementation uses go-lang regexp package.
+// The implementation uses go-lang regexp package, which is also the default
+// engine for "pattern". So the strings accepted by this format are those
+// usable as "pattern". ECMA 262 features not supported by go-lang regexp,
+// such as lookaround and backreferences, are reported as invalid. If
+// Compiler.CompileRegex is overridden, override this format as well.
 func isRegex(v interface{}) bool {
 	s, ok := v.(string)
 	if !ok {
@@ -139,6 +139,13 @@ func TestIsDuration(t *testing.T) {
 		{"P2W", true},     // valid: two weeks
 		{"P1Y2W", false},  // invalid: weeks cannot be combined with other units
 		{"P1", false},     // element without unit
+		{"P4W", true},     // valid: four weeks
+		{"P2W1D", false},  // invalid: weeks cannot be combined with days
+		{"P1WT1H", false}, // invalid: weeks cannot be combined with time elements
+		{"PW", false},     // invalid: week without digits
+		{"P1DT", false},   // invalid: no time elements after 'T'
+		{"PT1.5S", false}, // invalid: fractions are not allowed
+		{"P1D ", false},   // invalid: trailing space
 	}
 	for i, test := range tests {
 		if test.valid != isDuration(test.str) {
@@ -250,12 +257,77 @@ func TestIsURI(t *testing.T) {
 	}
 }
 
+func TestIsIRI(t *testing.T) {
+	tests := []test{
+		{"http://ƒøø.ßår/?∂éœ=πîx#πîüx", true},                       // with anchor tag
+		{"http://ƒøø.com/blah_(wîkïpédiå)_blah#ßité-1", true},        // with anchor tag and parentheses
+		{"http://ƒøø.ßår/?q=Test%20URL-encoded%20stuff", true},       // with URL-encoded stuff
+		{"http://-.~_!$&'()*+,;=:%40:80%2f::::::@example.com", true}, // with many special characters
+		{"http://[2001:0db8:85a3:0000:0000:8a2e:0370:7334]", true},   // ipv6
+		{"http://2001:0db8:85a3:0000:0000:8a2e:0370:7334", false},    // ipv6 without brackets
+		{"/abc", false},                          // relative IRI reference
+		{"\\\\WINDOWS\\filëßåré", false},         // invalid characters
+		{"âππ", false},                           // no scheme
+		{"http://example.com/?q=\uE000", true},   // iprivate in query
+		{"http://example.com/\uE000", false},     // iprivate in path
+		{"http://example.com/a b", false},        // space
+		{"http://example.com/%zz", false},        // invalid pct-encoded
+		{"http://example.com/\U000EFFFE", false}, // noncharacter
+	}
+	for i, test := range tests {
+		if test.valid != isIRI(test.str) {
+			t.Errorf("#%d: %q, valid %t, got valid %t", i, test.str, test.valid, !test.valid)
+		}
+	}
+}
+
+func TestIsIRIReference(t *testing.T) {
+	tests := []test{
+		{"http://ƒøø.ßår/?∂éœ=πîx#πîüx", true}, // a valid IRI
+		{"//ƒøø.ßår/?∂éœ=πîx#πîüx", true},      // a valid protocol-relative IRI Reference
+		{"/âππ", true},                   // a valid relative IRI Reference
+		{"âππ", true},                    // a valid IRI Reference
+		{"#ƒrägmênt", true},              // a valid IRI fragment
+		{"\\\\WINDOWS\\filëßåré", false}, // an invalid IRI Reference
+		{"#ƒräg\\mênt", false},           // an invalid IRI fragment
+		{"?\uE000#x", true},              // iprivate in query
+		{"#\uE000", false},               // iprivate in fragment
+	}
+	for i, test := range tests {
+		if test.valid != isIRIReference(test.str) {
+			t.Errorf("#%d: %q, valid %t, got valid %t", i, test.str, test.valid, !test.valid)
+		}
+	}
+}
+
 func TestIsURITemplate(t *testing.T) {
 	tests := []test{
 		{"http://example.com/dictionary/{term:1}/{term}", true},
 		{"http://example.com/dictionary/{term:1}/{term", false},
 		{"http://example.com/dictionary", true}, // without variables
 		{"dictionary/{term:1}/{term}", true},    // relative url-template
+		{"{foo", false},                         // unclosed expression
+		{"foo}", false},                         // unopened expression
+		{"{{foo}}", false},                      // nested expression
+		{"{}", false},                           // empty expression
+		{"{?x,y}", true},                        // multi-variable query expression
+		{"{+path}/here{#frag}", true},           // reserved and fragment expansion
+		{"{/list*}{;keys*}{.ext}{&y}", true},    // explode modifiers
+		{"{var:30}", true},                      // prefix modifier
+		{"{var:0}", false},                      // prefix must start with non-zero digit
+		{"{var:10000}", false},                  // prefix too long
+		{"{var:}", false},                       // prefix without length
+		{"{=var}", false},                       // reserved operator
+		{"{|var}", false},                       // reserved operator
+		{"{x,}", false},                         // empty varname
+		{"{a.b}", true},                         // dotted varname
+		{"{a..b}", false},                       // empty varname segment
+		{"{a%2Fb}", true},                       // pct-encoded varname
+		{"{a%2}", false},                        // invalid pct-encoded varname
+		{"{a b}", false},                        // space in varname
+		{"http://example.com/a b", false},       // space in literal
+		{"http://example.com/%zz", false},       // invalid pct-encoded literal
+		{"http://example.com/ƒøø/{x}", true},    // ucschar in literal
 	}
 	for i, test := range tests {
 		if test.valid != isURITemplate(test.str) {
@@ -268,6 +340,11 @@ func TestIsRegex(t *testing.T) {
 	tests := []test{
 		{"([abc])+\\s+$", true},
 		{"^(abc]", false}, // unclosed parenthesis
+		{"^[a-z]{2,3}(-[A-Z]{2})?$", true},
+		{"\\p{L}+", true},
+		{"a{2,1}", false}, // invalid repeat range
+		{"(?=a)b", false}, // lookahead: valid in ECMA 262, not supported by go regexp
+		{"(a)\\1", false}, // backreference: valid in ECMA 262, not supported by go regexp
 	}
 	for i, test := range tests {
 		if test.valid != isRegex(test.str) {
@@ -304,6 +381,7 @@ func TestIsJSONPointer(t *testing.T) {
 		{"#", false},
 		{"#/", false},
 		{"#a", false},
+		{"#/foo", false},
 		// some escaped, but not all
 		{"/~0~", false},
 		{"/~0/~", false},
@@ -311,6 +389,9 @@ func TestIsJSONPointer(t *testing.T) {
 		{"/~2", false},
 		{"/~-1", false},
 		{"/~~", false}, // multiple characters not escaped
+		{"/~01", true}, // ~0 followed by 1, not ~1
+		{"/~", false},
+		{"~0", false},
 		// isn't empty nor starts with /
 		{"a", false},
 		{"0", false},
@@ -335,6 +416,12 @@ func TestRelativeJSONPointer(t *testing.T) {
 		{"01/a", false},         // zero cannot be followed by other digits, plus json-pointer
 		{"01#", false},          // zero cannot be followed by other digits, plus octothorpe
 		{"", false},             // empty string
+		{"+1/foo/bar", false},   // explicit positive prefix
+		{"120/foo/bar", true},   // multi-digit integer prefix
+		{"10#", true},           // multi-digit integer prefix, plus octothorpe
+		{"00", false},           // zero cannot be followed by zero
+		{"1#/foo", false},       // octothorpe must be the last character
+		{"0/foo~2", false},      // invalid json-pointer escape
 	}
 	for i, test := range tests {
 		if test.valid != isRelativeJSONPointer(test.str) {
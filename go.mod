@@ -1,5 +1,3 @@
 module gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6
 
 go 1.19
-
-require github.com/santhosh-tekuri/jsonschema/v5 v5.3.1 // indirect
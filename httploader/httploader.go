@@ -5,15 +5,24 @@
 //
 // To use httploader, link this package into your program:
 //
-//	import _ "github.com/santhosh-tekuri/jsonschema/v5/httploader"
+//	import _ "gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6/httploader"
+//
+// To send custom headers or to limit the time and size of loading,
+// register a Loader instead:
+//
+//	l := &httploader.Loader{Timeout: 10 * time.Second, MaxBytes: 1 << 20}
+//	jsonschema.Loaders["http"] = l.Load
+//	jsonschema.Loaders["https"] = l.Load
 package httploader
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
-	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6"
 )
 
 // Client is the default HTTP Client used to Get the resource.
@@ -21,15 +30,98 @@ var Client = http.DefaultClient
 
 // Load loads resource from given http(s) url.
 func Load(url string) (io.ReadCloser, error) {
-	resp, err := Client.Get(url)
+	return LoadContext(context.Background(), url)
+}
+
+// LoadContext loads resource from given http(s) url, aborting the request if ctx is done.
+// It can be used as Compiler.LoadURLContext for http(s) urls.
+func LoadContext(ctx context.Context, url string) (io.ReadCloser, error) {
+	return (&Loader{}).LoadContext(ctx, url)
+}
+
+// Loader loads resources from http(s) urls, with custom client, headers
+// and limits on time and size. It can be used as Compiler.Loader, which
+// loads using LoadContext with the ctx passed to CompileContext.
+type Loader struct {
+	// Client used to Get the resource. If nil, package global Client is used.
+	Client *http.Client
+
+	// Header is added to each request. For example "Authorization"
+	// or "User-Agent".
+	Header http.Header
+
+	// Timeout limits the time taken to load resource, including reading
+	// its body. Zero means no timeout.
+	Timeout time.Duration
+
+	// MaxBytes limits the size of resource. Reading the body of larger
+	// resource fails. Zero means no limit.
+	MaxBytes int64
+}
+
+// Load loads resource from given http(s) url.
+func (l *Loader) Load(url string) (io.ReadCloser, error) {
+	return l.LoadContext(context.Background(), url)
+}
+
+// LoadContext loads resource from given http(s) url, aborting the request if ctx is done.
+// It can be used as Compiler.LoadURLContext for http(s) urls.
+func (l *Loader) LoadContext(ctx context.Context, url string) (io.ReadCloser, error) {
+	cancel := func() {}
+	if l.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, l.Timeout)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for name, values := range l.Header {
+		req.Header[name] = values
+	}
+	client := l.Client
+	if client == nil {
+		client = Client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
 		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
 		_ = resp.Body.Close()
+		cancel()
 		return nil, fmt.Errorf("%s returned status code %d", url, resp.StatusCode)
 	}
-	return resp.Body, nil
+	if l.MaxBytes > 0 && resp.ContentLength > l.MaxBytes {
+		_ = resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("%s is larger than %d bytes", url, l.MaxBytes)
+	}
+	return &body{resp.Body, url, l.MaxBytes, 0, cancel}, nil
+}
+
+// body is response body which enforces Loader limits.
+type body struct {
+	rc     io.ReadCloser
+	url    string
+	max    int64 // zero means no limit
+	n      int64 // bytes read so far
+	cancel context.CancelFunc
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.n += int64(n)
+	if b.max > 0 && b.n > b.max {
+		return 0, fmt.Errorf("%s is larger than %d bytes", b.url, b.max)
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	defer b.cancel()
+	return b.rc.Close()
 }
 
 func init() {
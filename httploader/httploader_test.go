@@ -0,0 +1,95 @@
+package httploader_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6"
+	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6/httploader"
+)
+
+func TestHTTPLoaderLimits(t *testing.T) {
+	big := `{"enum": ["` + strings.Repeat("x", 2000) + `"]}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow.json":
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+			fmt.Fprint(w, `{}`)
+		case "/big.json":
+			w.Header().Set("Content-Length", strconv.Itoa(len(big)))
+			fmt.Fprint(w, big)
+		case "/big-chunked.json":
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, big)
+		default:
+			fmt.Fprint(w, `{"type": "object"}`)
+		}
+	}))
+	defer ts.Close()
+
+	l := &httploader.Loader{Client: ts.Client(), Timeout: 100 * time.Millisecond, MaxBytes: 1000}
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"/small.json", true},
+		{"/slow.json", false},
+		{"/big.json", false},
+		{"/big-chunked.json", false},
+	}
+	for _, test := range tests {
+		c := jsonschema.NewCompiler()
+		c.LoadURL = l.Load
+		start := time.Now()
+		_, err := c.Compile(ts.URL + test.path)
+		if test.valid != (err == nil) {
+			t.Errorf("%s: valid %t, got %v", test.path, test.valid, err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Errorf("%s: took %v", test.path, d)
+		}
+	}
+
+	// zero value has no limits
+	c := jsonschema.NewCompiler()
+	c.LoadURL = (&httploader.Loader{Client: ts.Client()}).Load
+	if _, err := c.Compile(ts.URL + "/big-chunked.json"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPLoaderHeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("User-Agent") != "schema-fetcher" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"type": "object"}`)
+	}))
+	defer ts.Close()
+
+	l := &httploader.Loader{Client: ts.Client()}
+	c := jsonschema.NewCompiler()
+	c.LoadURL = l.Load
+	if _, err := c.Compile(ts.URL + "/schema.json"); err == nil {
+		t.Fatal("error expected without headers")
+	}
+
+	l.Header = http.Header{}
+	l.Header.Set("Authorization", "Bearer token")
+	l.Header.Set("User-Agent", "schema-fetcher")
+	c = jsonschema.NewCompiler()
+	c.LoadURLContext = l.LoadContext
+	if _, err := c.Compile(ts.URL + "/schema.json"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,102 @@
+package jsonschema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LintWarning describes a likely mistake in a schema, found when
+// compiling with Compiler.Lint. Such schema is valid per specification,
+// but some of its keywords have no effect.
+type LintWarning struct {
+	Location string // absolute location of the schema
+	Keyword  string // keyword that has no effect
+	Message  string
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.Location, w.Message)
+}
+
+// lintKeywords maps keywords to the instance type they apply to.
+var lintKeywords = map[string]string{
+	"minimum":               "number",
+	"maximum":               "number",
+	"exclusiveMinimum":      "number",
+	"exclusiveMaximum":      "number",
+	"multipleOf":            "number",
+	"minLength":             "string",
+	"maxLength":             "string",
+	"pattern":               "string",
+	"contentEncoding":       "string",
+	"contentMediaType":      "string",
+	"contentSchema":         "string",
+	"items":                 "array",
+	"additionalItems":       "array",
+	"prefixItems":           "array",
+	"contains":              "array",
+	"minItems":              "array",
+	"maxItems":              "array",
+	"uniqueItems":           "array",
+	"minContains":           "array",
+	"maxContains":           "array",
+	"unevaluatedItems":      "array",
+	"properties":            "object",
+	"patternProperties":     "object",
+	"additionalProperties":  "object",
+	"required":              "object",
+	"minProperties":         "object",
+	"maxProperties":         "object",
+	"propertyNames":         "object",
+	"dependencies":          "object",
+	"dependentRequired":     "object",
+	"dependentSchemas":      "object",
+	"unevaluatedProperties": "object",
+}
+
+// lint appends to c.LintWarnings, the keywords in schema m that
+// do not apply to any of the types allowed by its "type" keyword.
+// nothing is reported if "type" is missing. keywords unknown to the
+// draft of r are ignored, like they are by the compiler.
+func (c *Compiler) lint(r *resource, res *resource, m map[string]interface{}) {
+	var types []string
+	switch t := m["type"].(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			if item, ok := item.(string); ok {
+				types = append(types, item)
+			}
+		}
+	default:
+		return
+	}
+	allowed := func(want string) bool {
+		for _, t := range types {
+			if t == want || (want == "number" && t == "integer") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var kws []string
+	for kw := range m {
+		if _, ok := r.draft.keywords[kw]; !ok {
+			continue
+		}
+		if want, ok := lintKeywords[kw]; ok && !allowed(want) {
+			kws = append(kws, kw)
+		}
+	}
+	sort.Strings(kws)
+	for _, kw := range kws {
+		c.LintWarnings = append(c.LintWarnings, LintWarning{
+			Location: r.url + res.floc,
+			Keyword:  kw,
+			Message:  fmt.Sprintf("%q has no effect, since it applies only to %s, but type is %s", kw, lintKeywords[kw], strings.Join(types, " or ")),
+		})
+	}
+}
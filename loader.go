@@ -58,3 +58,26 @@ var LoadURL = func(s string) (io.ReadCloser, error) {
 	}
 	return loader(s)
 }
+
+// ResourceLoader loads documents referred by schemas, from any source such
+// as database, object store or local files. Loaders can be composed, to add
+// caching or metrics for example.
+//
+// If a ResourceLoader also has method
+//
+//	LoadContext(ctx context.Context, uri string) (io.ReadCloser, error)
+//
+// Compiler uses it instead of Load, with the ctx passed to CompileContext.
+// *httploader.Loader is such ResourceLoader.
+type ResourceLoader interface {
+	// Load loads the document at given absolute uri.
+	Load(uri string) (io.ReadCloser, error)
+}
+
+// LoaderFunc is an adapter to use ordinary function as ResourceLoader.
+type LoaderFunc func(uri string) (io.ReadCloser, error)
+
+// Load calls f(uri).
+func (f LoaderFunc) Load(uri string) (io.ReadCloser, error) {
+	return f(uri)
+}
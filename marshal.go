@@ -0,0 +1,208 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"math/big"
+	"strconv"
+)
+
+// MarshalJSON returns json document equivalent to the keywords held by
+// compiled schema s. Annotations are included only if they were extracted
+// during compilation. Extensions are not included, since their keywords
+// are not known.
+//
+// The output is not byte-identical to the original schema document, but
+// it is stable: keys are sorted and numbers are in canonical form.
+// $ref and $dynamicRef are emitted as absolute location of the referred
+// schema, rather than inlining it. So MarshalJSON terminates on recursive
+// schemas. $dynamicRef to an anchor keeps the anchor, and $recursiveRef is
+// emitted as "#", so that they are still resolved dynamically. The root schema of a document carries $schema
+// of its draft, so that output compiles under the same draft.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	if s.Always != nil {
+		return json.Marshal(*s.Always)
+	}
+	m := make(map[string]interface{})
+	put := func(kw string, sch *Schema) {
+		if sch != nil {
+			m[kw] = sch
+		}
+	}
+	putList := func(kw string, list []*Schema) {
+		if len(list) > 0 {
+			m[kw] = list
+		}
+	}
+	putMap := func(kw string, sm map[string]*Schema) {
+		if len(sm) > 0 {
+			m[kw] = sm
+		}
+	}
+	putInt := func(kw string, i int) {
+		if i != -1 {
+			m[kw] = i
+		}
+	}
+	putString := func(kw string, str string) {
+		if str != "" {
+			m[kw] = str
+		}
+	}
+	putBool := func(kw string, b bool) {
+		if b {
+			m[kw] = true
+		}
+	}
+	draft4 := s.Draft != nil && s.Draft.version == 4
+	putRat := func(kw string, r, exclusive *big.Rat, exclusiveKw string) {
+		switch {
+		case exclusive != nil && draft4:
+			m[kw], m[exclusiveKw] = ratNumber(exclusive), true
+		case exclusive != nil:
+			m[exclusiveKw] = ratNumber(exclusive)
+		}
+		if r != nil {
+			m[kw] = ratNumber(r)
+		}
+	}
+
+	// type agnostic
+	if s.Draft != nil && s.Location == s.docURL+"#" {
+		m["$schema"] = s.Draft.URL()
+	}
+	if s.Ref != nil {
+		m["$ref"] = s.Ref.Location
+	}
+	if s.RecursiveRef != nil {
+		// only "#" is allowed, it is resolved dynamically
+		m["$recursiveRef"] = "#"
+	}
+	if s.DynamicRef != nil {
+		if s.dynamicRefAnchor != "" {
+			// anchor is kept, so that it is resolved dynamically
+			u, _ := split(s.DynamicRef.Location)
+			m["$dynamicRef"] = u + "#" + s.dynamicRefAnchor
+		} else {
+			m["$dynamicRef"] = s.DynamicRef.Location
+		}
+	}
+	putBool("$recursiveAnchor", s.RecursiveAnchor)
+	putString("$dynamicAnchor", s.DynamicAnchor)
+	putString("format", s.Format)
+	switch len(s.Types) {
+	case 0:
+	case 1:
+		m["type"] = s.Types[0]
+	default:
+		m["type"] = s.Types
+	}
+	if len(s.Constant) > 0 {
+		m["const"] = s.Constant[0]
+	}
+	if s.Enum != nil {
+		m["enum"] = s.Enum
+	}
+	put("not", s.Not)
+	putList("allOf", s.AllOf)
+	putList("anyOf", s.AnyOf)
+	putList("oneOf", s.OneOf)
+	put("if", s.If)
+	put("then", s.Then)
+	put("else", s.Else)
+
+	// object
+	putInt("minProperties", s.MinProperties)
+	putInt("maxProperties", s.MaxProperties)
+	if len(s.Required) > 0 {
+		m["required"] = s.Required
+	}
+	putMap("properties", s.Properties)
+	put("propertyNames", s.PropertyNames)
+	if len(s.PatternProperties) > 0 {
+		pp := make(map[string]*Schema, len(s.PatternProperties))
+		for re, sch := range s.PatternProperties {
+			pp[re.String()] = sch
+		}
+		m["patternProperties"] = pp
+	}
+	if s.AdditionalProperties != nil {
+		m["additionalProperties"] = s.AdditionalProperties
+	}
+	if len(s.Dependencies) > 0 {
+		m["dependencies"] = s.Dependencies
+	}
+	if len(s.DependentRequired) > 0 {
+		m["dependentRequired"] = s.DependentRequired
+	}
+	putMap("dependentSchemas", s.DependentSchemas)
+	put("unevaluatedProperties", s.UnevaluatedProperties)
+
+	// array
+	putInt("minItems", s.MinItems)
+	putInt("maxItems", s.MaxItems)
+	putBool("uniqueItems", s.UniqueItems)
+	if s.Items != nil {
+		m["items"] = s.Items
+	}
+	if s.AdditionalItems != nil {
+		m["additionalItems"] = s.AdditionalItems
+	}
+	putList("prefixItems", s.PrefixItems)
+	put("items", s.Items2020)
+	put("contains", s.Contains)
+	if s.MinContains != 1 {
+		m["minContains"] = s.MinContains
+	}
+	putInt("maxContains", s.MaxContains)
+	put("unevaluatedItems", s.UnevaluatedItems)
+
+	// string
+	putInt("minLength", s.MinLength)
+	putInt("maxLength", s.MaxLength)
+	if s.Pattern != nil {
+		m["pattern"] = s.Pattern.String()
+	}
+	putString("contentEncoding", s.ContentEncoding)
+	putString("contentMediaType", s.ContentMediaType)
+	put("contentSchema", s.ContentSchema)
+
+	// number
+	putRat("minimum", s.Minimum, s.ExclusiveMinimum, "exclusiveMinimum")
+	putRat("maximum", s.Maximum, s.ExclusiveMaximum, "exclusiveMaximum")
+	if s.MultipleOf != nil {
+		m["multipleOf"] = ratNumber(s.MultipleOf)
+	}
+
+	// annotations
+	putString("title", s.Title)
+	putString("description", s.Description)
+	if s.Default != nil {
+		m["default"] = s.Default
+	}
+	putString("$comment", s.Comment)
+	putBool("readOnly", s.ReadOnly)
+	putBool("writeOnly", s.WriteOnly)
+	if len(s.Examples) > 0 {
+		m["examples"] = s.Examples
+	}
+	putBool("deprecated", s.Deprecated)
+
+	if s.ErrorMessage != nil {
+		if s.ErrorMessage.Text != "" {
+			m["errorMessage"] = s.ErrorMessage.Text
+		} else {
+			m["errorMessage"] = s.ErrorMessage.Keywords
+		}
+	}
+	return json.Marshal(m)
+}
+
+// ratNumber returns r as json number. integers are exact, other
+// values are nearest float64.
+func ratNumber(r *big.Rat) json.Number {
+	if r.IsInt() {
+		return json.Number(r.Num().String())
+	}
+	f, _ := r.Float64()
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+}
@@ -0,0 +1,88 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MaxBodyBytes is the limit on size of request body read by Middleware.
+const MaxBodyBytes = 10 << 20
+
+// Middleware returns http handler, which validates json body of incoming
+// requests against s, before passing them to next. It is MiddlewareN with
+// limit MaxBodyBytes.
+//
+// Only requests whose Content-Type is json, i.e. "application/json" or
+// a media type with "+json" suffix, are validated. Others are passed to
+// next as they are.
+//
+// If the body is not valid, it responds with status 400 Bad Request. The
+// response is the basic output of the *ValidationError as json, or the
+// error text if the body is not json at all. If the body cannot be
+// validated, for example because of infinite loop in s, it responds with
+// status 500 Internal Server Error. Otherwise the request is passed to
+// next with r.Body replaced by a reader of the same bytes.
+func (s *Schema) Middleware(next http.Handler) http.Handler {
+	return s.MiddlewareN(next, MaxBodyBytes)
+}
+
+// MiddlewareN is like Middleware, but reads at most maxBytes of request
+// body. If the body is larger, it responds with status 413 Request Entity
+// Too Large. maxBytes <= 0 means no limit.
+func (s *Schema) MiddlewareN(next http.Handler, maxBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isJSONMediaType(r.Header.Get("Content-Type")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rc := r.Body
+		if maxBytes > 0 {
+			rc = http.MaxBytesReader(w, rc, maxBytes)
+		}
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v, p, err := s.decodePositions(bytes.NewReader(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.validateDecoded(v, p); err != nil {
+			ve, ok := err.(*ValidationError)
+			if !ok {
+				// not a problem of the request, so details are not leaked
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(ve.BasicOutput())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isJSONMediaType tells whether content type ct is json.
+func isJSONMediaType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
@@ -3,9 +3,50 @@ package msg
 import (
 	"fmt"
 	"math/big"
+	"reflect"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// Key returns stable key identifying the kind of message m, which can be
+// used to lookup translations. It is the name of the type of m with first
+// letter in lower case. For example key of MinLength is "minLength".
+func Key(m fmt.Stringer) string {
+	t := reflect.TypeOf(m)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return lowerFirst(t.Name())
+}
+
+// Args returns exported fields of message m, keyed by field name with
+// first letter in lower case. For example args of MinLength are "got" and "want".
+func Args(m fmt.Stringer) map[string]interface{} {
+	v := reflect.ValueOf(m)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	args := make(map[string]interface{})
+	if v.Kind() != reflect.Struct {
+		return args
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Type().Field(i); f.IsExported() {
+			args[lowerFirst(f.Name)] = v.Field(i).Interface()
+		}
+	}
+	return args
+}
+
+func lowerFirst(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[size:]
+}
+
 // Empty captures error fields for empty message.
 type Empty struct{}
 
@@ -32,8 +73,9 @@ func (d Type) String() string {
 
 // Format captures error fields for 'format'.
 type Format struct {
-	Got  interface{} // the value we got
-	Want string      // format that is allowed
+	Got    interface{} // the value we got
+	Want   string      // format that is allowed
+	Detail string      // why the value is not valid. empty if not known
 }
 
 func (d Format) String() string {
@@ -41,6 +83,9 @@ func (d Format) String() string {
 	if v, ok := got.(string); ok {
 		got = quote(v)
 	}
+	if d.Detail != "" {
+		return fmt.Sprintf("%v is not valid %s: %s", got, quote(d.Want), d.Detail)
+	}
 	return fmt.Sprintf("%v is not valid %s", got, quote(d.Want))
 }
 
@@ -86,6 +131,38 @@ func (d AdditionalProperties) String() string {
 	return fmt.Sprintf("additionalProperties %s not allowed", strings.Join(pnames, ", "))
 }
 
+// UnevaluatedProperties captures error fields for 'unevaluatedProperties', when it is false.
+type UnevaluatedProperties struct {
+	Got []string // unevaluated properties we got
+}
+
+func (d UnevaluatedProperties) String() string {
+	pnames := make([]string, 0, len(d.Got))
+	for _, pname := range d.Got {
+		pnames = append(pnames, quote(pname))
+	}
+	return fmt.Sprintf("unevaluatedProperties %s not allowed", strings.Join(pnames, ", "))
+}
+
+// PropertyNames captures error fields for 'propertyNames'.
+type PropertyNames struct {
+	Got string // property name that is invalid
+}
+
+func (d PropertyNames) String() string {
+	return fmt.Sprintf("invalid property name %s", quote(d.Got))
+}
+
+// PatternProperties captures error fields for 'patternProperties'.
+type PatternProperties struct {
+	Got  string // property name that matched the pattern
+	Want string // pattern matched
+}
+
+func (d PatternProperties) String() string {
+	return fmt.Sprintf("invalid property %s matching pattern %s", quote(d.Got), quote(d.Want))
+}
+
 // DependentRequired captures error fields for 'dependentRequired', 'dependencies'.
 type DependentRequired struct {
 	Want string // property that is required
@@ -96,6 +173,15 @@ func (d DependentRequired) String() string {
 	return fmt.Sprintf("property %s is required, if %s property exists", quote(d.Want), quote(d.Got))
 }
 
+// DependentSchemas captures error fields for 'dependentSchemas', 'dependencies'.
+type DependentSchemas struct {
+	Got string // property whose dependent schema failed
+}
+
+func (d DependentSchemas) String() string {
+	return fmt.Sprintf("dependent schema of property %s failed", quote(d.Got))
+}
+
 // MinItems captures error fields for 'minItems'.
 type MinItems struct {
 	Got  int // num items we got
@@ -133,7 +219,7 @@ type MaxContains struct {
 }
 
 func (d MaxContains) String() string {
-	return fmt.Sprintf("maximum %d valid items required, but found %d valid items", d.Want, len(d.Got))
+	return fmt.Sprintf("maximum %d valid items allowed, but found %d valid items", d.Want, len(d.Got))
 }
 
 // UniqueItems captures error fields for 'uniqueItems'.
@@ -191,6 +277,20 @@ func (d Schema) String() string {
 	return fmt.Sprintf("doesn't validate with %s", quote(d.Want))
 }
 
+// Schemas captures error fields for jsonschema.ValidateAll, when the
+// document is not valid against some of the schemas.
+type Schemas struct {
+	Got []string // urls of schemas that did not match
+}
+
+func (d Schemas) String() string {
+	got := make([]string, len(d.Got))
+	for i, u := range d.Got {
+		got[i] = quote(u)
+	}
+	return fmt.Sprintf("doesn't validate with schemas %s", strings.Join(got, ", "))
+}
+
 // AdditionalItems captures error fields for 'additionalItems'.
 type AdditionalItems struct {
 	Got  int // num items we got
@@ -201,6 +301,17 @@ func (d AdditionalItems) String() string {
 	return fmt.Sprintf("only %d items are allowed, but found %d items", d.Want, d.Got)
 }
 
+// UnevaluatedItems captures error fields for 'unevaluatedItems', when it is false.
+type UnevaluatedItems struct {
+	Got []int // indexes of unevaluated items we got
+}
+
+func (d UnevaluatedItems) String() string {
+	got := fmt.Sprintf("%v", d.Got)
+	got = got[1 : len(got)-1]
+	return fmt.Sprintf("unevaluatedItems at index %s not allowed", got)
+}
+
 // MinLength captures error fields for 'minLength'.
 type MinLength struct {
 	Got  int // length of string we got
@@ -286,18 +397,32 @@ func (d MultipleOf) String() string {
 	return fmt.Sprintf("%v not multipleOf %v", d.Got, want)
 }
 
-// Then captures error fields for 'then'.
+// ReadOnly captures error fields for 'readOnly', when it is asserted.
+type ReadOnly struct{}
+
+func (ReadOnly) String() string {
+	return "value is readOnly"
+}
+
+// WriteOnly captures error fields for 'writeOnly', when it is asserted.
+type WriteOnly struct{}
+
+func (WriteOnly) String() string {
+	return "value is writeOnly"
+}
+
+// Then captures error fields for 'then', i.e. 'if' matched but 'then' failed.
 type Then struct{}
 
 func (Then) String() string {
-	return "if-then failed"
+	return "if matched, but then failed"
 }
 
-// Else captures error fields for 'else'.
+// Else captures error fields for 'else', i.e. 'if' did not match and 'else' failed.
 type Else struct{}
 
 func (Else) String() string {
-	return "if-else failed"
+	return "if did not match, and else failed"
 }
 
 // Const captures error fields for 'const'.
@@ -373,6 +498,15 @@ func (ContentSchema) String() string {
 	return "value is not valid json"
 }
 
+// Custom captures error fields for message specified by 'errorMessage'.
+type Custom struct {
+	Text string // message text from schema
+}
+
+func (d Custom) String() string {
+	return d.Text
+}
+
 // quote returns single-quoted string
 func quote(s string) string {
 	s = fmt.Sprintf("%q", s)
@@ -0,0 +1,114 @@
+package jsonschema
+
+// OpenAPI 3.1 schema object dialect. It is draft 2020-12 with additional
+// keywords of OpenAPI base vocabulary.
+const (
+	openAPIDialect = "https://spec.openapis.org/oas/3.1/dialect/base"
+	openAPIVocab   = "https://spec.openapis.org/oas/3.1/vocab/base"
+)
+
+// openAPIKeywords are the keywords of OpenAPI base vocabulary.
+// they are annotations, which do not affect validation.
+var openAPIKeywords = map[string]struct{}{
+	"discriminator": {},
+	"xml":           {},
+	"externalDocs":  {},
+	"example":       {},
+}
+
+// openAPI tells whether resource r is in OpenAPI 3.1 dialect.
+func (c *Compiler) openAPI(r *resource) bool {
+	if c.OpenAPI {
+		return true
+	}
+	if m, ok := r.doc.(map[string]interface{}); ok {
+		if sch, ok := m["$schema"].(string); ok {
+			sch, _ = split(sch)
+			return sch == openAPIDialect
+		}
+	}
+	return false
+}
+
+func init() {
+	vocabSchemas[openAPIDialect] = `{
+		"$id": "https://spec.openapis.org/oas/3.1/dialect/base",
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "OpenAPI 3.1 Schema Object Dialect",
+		"description": "A JSON Schema dialect describing schemas found in OpenAPI documents",
+		"$vocabulary": {
+			"https://json-schema.org/draft/2020-12/vocab/core": true,
+			"https://json-schema.org/draft/2020-12/vocab/applicator": true,
+			"https://json-schema.org/draft/2020-12/vocab/unevaluated": true,
+			"https://json-schema.org/draft/2020-12/vocab/validation": true,
+			"https://json-schema.org/draft/2020-12/vocab/meta-data": true,
+			"https://json-schema.org/draft/2020-12/vocab/format-annotation": true,
+			"https://json-schema.org/draft/2020-12/vocab/content": true,
+			"https://spec.openapis.org/oas/3.1/vocab/base": false
+		},
+		"$dynamicAnchor": "meta",
+		"allOf": [
+			{ "$ref": "https://json-schema.org/draft/2020-12/schema" },
+			{ "$ref": "https://spec.openapis.org/oas/3.1/meta/base" }
+		]
+	}`
+	vocabSchemas["https://spec.openapis.org/oas/3.1/meta/base"] = `{
+		"$id": "https://spec.openapis.org/oas/3.1/meta/base",
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "OAS Base vocabulary",
+		"description": "A JSON Schema Vocabulary used in the OpenAPI Schema Dialect",
+		"$vocabulary": {
+			"https://spec.openapis.org/oas/3.1/vocab/base": true
+		},
+		"$dynamicAnchor": "meta",
+		"type": ["object", "boolean"],
+		"properties": {
+			"example": true,
+			"discriminator": { "$ref": "#/$defs/discriminator" },
+			"externalDocs": { "$ref": "#/$defs/external-docs" },
+			"xml": { "$ref": "#/$defs/xml" }
+		},
+		"$defs": {
+			"extensible": {
+				"patternProperties": {
+					"^x-": true
+				}
+			},
+			"discriminator": {
+				"$ref": "#/$defs/extensible",
+				"type": "object",
+				"properties": {
+					"mapping": {
+						"type": "object",
+						"additionalProperties": { "type": "string" }
+					},
+					"propertyName": { "type": "string" }
+				},
+				"required": ["propertyName"],
+				"unevaluatedProperties": false
+			},
+			"external-docs": {
+				"$ref": "#/$defs/extensible",
+				"type": "object",
+				"properties": {
+					"url": { "type": "string", "format": "uri-reference" },
+					"description": { "type": "string" }
+				},
+				"required": ["url"],
+				"unevaluatedProperties": false
+			},
+			"xml": {
+				"$ref": "#/$defs/extensible",
+				"type": "object",
+				"properties": {
+					"name": { "type": "string" },
+					"namespace": { "type": "string", "format": "uri" },
+					"prefix": { "type": "string" },
+					"attribute": { "type": "boolean" },
+					"wrapped": { "type": "boolean" }
+				},
+				"unevaluatedProperties": false
+			}
+		}
+	}`
+}
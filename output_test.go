@@ -0,0 +1,96 @@
+package jsonschema_test
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6"
+)
+
+func TestBasicOutput(t *testing.T) {
+	sch, err := jsonschema.CompileString("schema.json", `{
+		"properties": {
+			"a/b~c d": {"type": "string"}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sch.Validate(decodeString(t, `{"a/b~c d": 1}`))
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	out := err.(*jsonschema.ValidationError).BasicOutput()
+	if out.Valid {
+		t.Error("valid: got true, want false")
+	}
+	leaf := out.Errors[len(out.Errors)-1]
+	if got, want := leaf.InstanceLocation, "/a~1b~0c d"; got != want {
+		t.Errorf("instanceLocation: got %q, want %q", got, want)
+	}
+	if got, want := leaf.KeywordLocation, "/properties/a~1b~0c d/type"; got != want {
+		t.Errorf("keywordLocation: got %q, want %q", got, want)
+	}
+	if got, want := leaf.AbsoluteKeywordLocation, "#/properties/a~1b~0c%20d/type"; !strings.HasSuffix(got, want) {
+		t.Errorf("absoluteKeywordLocation: got %q, want suffix %q", got, want)
+	}
+}
+
+func TestLeafErrors(t *testing.T) {
+	sch, err := jsonschema.CompileString("schema.json", `{
+		"properties": {
+			"name": {"type": "string", "minLength": 3},
+			"age": {
+				"anyOf": [
+					{"type": "integer"},
+					{"type": "string", "pattern": "^[0-9]+$"}
+				]
+			}
+		},
+		"required": ["id"]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sch.Validate(decodeString(t, `{"name": "ab", "age": "x"}`))
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	var got []string
+	for _, leaf := range err.(*jsonschema.ValidationError).LeafErrors() {
+		if len(leaf.Causes) != 0 {
+			t.Errorf("%s: leaf must not have causes", leaf.KeywordLocation)
+		}
+		got = append(got, leaf.InstanceLocation+" "+leaf.KeywordLocation)
+	}
+	sort.Strings(got)
+	want := []string{
+		" /required",
+		"/age /properties/age/anyOf/0/type",
+		"/age /properties/age/anyOf/1/pattern",
+		"/name /properties/name/minLength",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLeafErrorsDedup(t *testing.T) {
+	leaf := func(vloc, kloc string) *jsonschema.ValidationError {
+		return &jsonschema.ValidationError{InstanceLocation: vloc, KeywordLocation: kloc}
+	}
+	ve := &jsonschema.ValidationError{
+		Causes: []*jsonschema.ValidationError{
+			{Causes: []*jsonschema.ValidationError{leaf("/a", "/x"), leaf("/b", "/x")}},
+			{Causes: []*jsonschema.ValidationError{leaf("/a", "/x"), leaf("/a", "/y")}},
+		},
+	}
+	var got []string
+	for _, leaf := range ve.LeafErrors() {
+		got = append(got, leaf.InstanceLocation+" "+leaf.KeywordLocation)
+	}
+	if want := []string{"/a /x", "/b /x", "/a /y"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
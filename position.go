@@ -0,0 +1,135 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Position is the position of json value in the document it is decoded from.
+type Position struct {
+	Offset int64 // byte offset, starting at 0
+	Line   int   // line number, starting at 1
+	Column int   // byte offset within line, starting at 1
+}
+
+// positions maps instance locations of json document to their byte offsets.
+type positions struct {
+	offsets    map[string]int64
+	lineStarts []int64 // byte offsets at which each line starts
+}
+
+// newPositions scans json document in data for the offsets of its values.
+func newPositions(data []byte) (*positions, error) {
+	p := &positions{
+		offsets:    make(map[string]int64),
+		lineStarts: []int64{0},
+	}
+	for i, b := range data {
+		if b == '\n' {
+			p.lineStarts = append(p.lineStarts, int64(i+1))
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var walk func(ptr string) error
+	walk = func(ptr string) error {
+		// value starts after whitespace and separators of previous token
+		off := decoder.InputOffset()
+		for off < int64(len(data)) {
+			if b := data[off]; b != ' ' && b != '\t' && b != '\n' && b != '\r' && b != ':' && b != ',' {
+				break
+			}
+			off++
+		}
+		p.offsets[ptr] = off
+
+		t, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case json.Delim('{'):
+			for decoder.More() {
+				t, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				if err := walk(ptr + "/" + escapePtr(t.(string))); err != nil {
+					return err
+				}
+			}
+		case json.Delim('['):
+			for i := 0; decoder.More(); i++ {
+				if err := walk(ptr + "/" + strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		default:
+			return nil
+		}
+		_, err = decoder.Token() // closing delim
+		return err
+	}
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// position returns the position of value at instance location vloc.
+func (p *positions) position(vloc string) *Position {
+	off, ok := p.offsets[vloc]
+	if !ok {
+		return nil
+	}
+	line := sort.Search(len(p.lineStarts), func(i int) bool { return p.lineStarts[i] > off })
+	return &Position{
+		Offset: off,
+		Line:   line,
+		Column: int(off-p.lineStarts[line-1]) + 1,
+	}
+}
+
+// fill sets Position of err and its causes.
+func (p *positions) fill(err *ValidationError) {
+	err.Position = p.position(err.InstanceLocation)
+	for _, cause := range err.Causes {
+		p.fill(cause)
+	}
+}
+
+// decodePositions is like decode, but also returns the positions of values
+// in the document, if s is compiled with Compiler.TrackPositions.
+func (s *Schema) decodePositions(r io.Reader) (interface{}, *positions, error) {
+	if !s.trackPositions {
+		v, err := s.decode(r)
+		return v, nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := s.decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := newPositions(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, p, nil
+}
+
+// validateDecoded validates v decoded with positions p, using s.
+// If p is not nil, Position is set on returned ValidationError.
+func (s *Schema) validateDecoded(v interface{}, p *positions) error {
+	err := s.Validate(v)
+	if ve, ok := err.(*ValidationError); ok && p != nil {
+		p.fill(ve)
+	}
+	return err
+}
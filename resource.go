@@ -1,6 +1,7 @@
 package jsonschema
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -15,9 +16,11 @@ type resource struct {
 	url          string // base url of resource. can be empty
 	floc         string // fragment with json-pointer from root resource
 	doc          interface{}
+	order        map[string][]string // key order of json objects in doc, by json-pointer. only applicable for root resource
 	draft        *Draft
 	subresources map[string]*resource // key is floc. only applicable for root resource
 	schema       *Schema
+	builtin      bool // whether doc is the embedded metaschema of a draft
 }
 
 func (r *resource) String() string {
@@ -183,6 +186,22 @@ func (r *resource) baseURL(floc string) string {
 	return r.url
 }
 
+// canonicalURL returns absolute url of subschema at floc, relative to
+// the nearest enclosing resource that has its own base url.
+func (r *resource) canonicalURL(floc string) string {
+	for prefix := floc; ; {
+		if sr, ok := r.subresources[prefix]; ok && sr.url != "" {
+			return sr.url + "#" + floc[len(prefix):]
+		}
+		slash := strings.LastIndexByte(prefix, '/')
+		if slash == -1 {
+			break
+		}
+		prefix = prefix[:slash]
+	}
+	return r.url + floc
+}
+
 // url helpers ---
 
 func toAbs(s string) (string, error) {
@@ -264,8 +283,16 @@ func (s *Schema) loc() string {
 }
 
 func unmarshal(r io.Reader) (interface{}, error) {
+	return decodeJSON(r, true)
+}
+
+// decodeJSON decodes json document from r. numbers are decoded
+// as json.Number if useNumber is true, otherwise as float64.
+func decodeJSON(r io.Reader, useNumber bool) (interface{}, error) {
 	decoder := json.NewDecoder(r)
-	decoder.UseNumber()
+	if useNumber {
+		decoder.UseNumber()
+	}
 	var doc interface{}
 	if err := decoder.Decode(&doc); err != nil {
 		return nil, err
@@ -275,3 +302,115 @@ func unmarshal(r io.Reader) (interface{}, error) {
 	}
 	return doc, nil
 }
+
+// keyOrder returns the keys of each json object in data, in the order
+// they appear. The returned map is keyed by json-pointer of the object.
+func keyOrder(data []byte) (map[string][]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	order := make(map[string][]string)
+	var walk func(ptr string) error
+	walk = func(ptr string) error {
+		t, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case json.Delim('{'):
+			var keys []string
+			seen := make(map[string]bool)
+			for decoder.More() {
+				t, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				key := t.(string)
+				if !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+				if err := walk(ptr + "/" + escape(key)); err != nil {
+					return err
+				}
+			}
+			order[ptr] = keys
+		case json.Delim('['):
+			for i := 0; decoder.More(); i++ {
+				if err := walk(ptr + "/" + strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		default:
+			return nil
+		}
+		_, err = decoder.Token() // closing delim
+		return err
+	}
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// stripComments returns data with "//" and "/* */" comments and trailing
+// commas in objects and arrays replaced by spaces, so that it can be
+// decoded as strict json. Newlines are kept, so offsets and line numbers
+// in decode errors still point into the original data.
+func stripComments(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	copy(out, data)
+	blank := func(from, to int) {
+		for i := from; i < to; i++ {
+			if out[i] != '\n' && out[i] != '\r' {
+				out[i] = ' '
+			}
+		}
+	}
+
+	// comments
+	for i := 0; i < len(out); i++ {
+		switch {
+		case out[i] == '"':
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				}
+			}
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			end := bytes.IndexByte(out[i:], '\n')
+			if end == -1 {
+				end = len(out) - i
+			}
+			blank(i, i+end)
+			i += end
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated comment at offset %d", i)
+			}
+			blank(i, i+2+end+2)
+			i += 2 + end + 1
+		}
+	}
+
+	// trailing commas
+	for i := 0; i < len(out); i++ {
+		switch out[i] {
+		case '"':
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				}
+			}
+		case ',':
+			j := i + 1
+			for j < len(out) && (out[j] == ' ' || out[j] == '\t' || out[j] == '\n' || out[j] == '\r') {
+				j++
+			}
+			if j < len(out) && (out[j] == '}' || out[j] == ']') {
+				out[i] = ' '
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,37 @@
+package jsonschema
+
+// Branch captures the subschema of oneOf or anyOf that matched during validation.
+type Branch struct {
+	KeywordLocation         string // validation path of oneOf/anyOf keyword
+	AbsoluteKeywordLocation string // absolute location of oneOf/anyOf keyword
+	InstanceLocation        string // location of the json value matched
+	Index                   int    // index of matched subschema
+}
+
+// Result captures the result of successful validation.
+type Result struct {
+	Branches []Branch           // matched branches of oneOf and anyOf
+	Warnings []*ValidationError // format violations, if compiled with FormatWarn
+}
+
+// Branch returns the index of subschema that matched, for oneOf or anyOf
+// at keyword location kloc applied to instance location vloc.
+// It returns false, if no such branch is recorded.
+func (r *Result) Branch(kloc, vloc string) (int, bool) {
+	for _, b := range r.Branches {
+		if b.KeywordLocation == kloc && b.InstanceLocation == vloc {
+			return b.Index, true
+		}
+	}
+	return -1, false
+}
+
+// branch returns the Branch of keyword of s, that matched json value at vloc.
+func (s *Schema) branch(scope []schemaRef, keyword string, vloc string, index int) Branch {
+	return Branch{
+		KeywordLocation:         keywordLocation(scope, keyword),
+		AbsoluteKeywordLocation: joinPtr(s.Location, keyword),
+		InstanceLocation:        vloc,
+		Index:                   index,
+	}
+}
@@ -1,23 +1,39 @@
 package jsonschema
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"hash/maphash"
+	"io"
+	"math"
 	"math/big"
 	"net/url"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode/utf8"
 
 	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6/msg"
 )
 
 // A Schema represents compiled version of json-schema.
+//
+// The keyword values are exposed as exported fields, so compiled schema can be
+// introspected. Absent keywords have zero value, except that absent integer
+// keywords like minLength are -1, and absent number keywords like minimum are
+// nil. So "minimum": 0 can be told apart from missing minimum.
 type Schema struct {
 	Location string // absolute location
+	docURL   string // url of the document, s is loaded from
+
+	// compiler that compiled s. Subschema uses it to compile subschemas
+	// that are not referred, like unused $defs.
+	compiler *Compiler
 
 	Draft          *Draft // draft used by schema.
 	meta           *Schema
@@ -27,14 +43,16 @@ type Schema struct {
 	// type agnostic validations
 	Format           string
 	format           func(interface{}) bool
-	Always           *bool // always pass/fail. used when booleans are used as schemas in draft-07.
+	formatDetail     func(interface{}) error // used instead of format, if not nil
+	formatWarn       bool                    // whether format violations are warnings
+	Always           *bool                   // always pass/fail. used when booleans are used as schemas in draft-07.
 	Ref              *Schema
 	RecursiveAnchor  bool
 	RecursiveRef     *Schema
 	DynamicAnchor    string
 	DynamicRef       *Schema
 	dynamicRefAnchor string
-	Types            []string      // allowed types.
+	Types            []string      // allowed types. a number with zero fractional part, like 1.0 or 1e2, is "integer" in all drafts.
 	Constant         []interface{} // first element in slice is constant value. note: slice is used to capture nil constant.
 	Enum             []interface{} // allowed values.
 	Not              *Schema
@@ -50,6 +68,7 @@ type Schema struct {
 	MaxProperties         int      // -1 if not specified.
 	Required              []string // list of required properties.
 	Properties            map[string]*Schema
+	PropertyOrder         []string // names of Properties in declaration order. set only if Compiler.PreserveOrder is set.
 	PropertyNames         *Schema
 	RegexProperties       bool // property names must be valid regex. used only in draft4 as workaround in metaschema.
 	PatternProperties     map[Regexp]*Schema
@@ -100,6 +119,14 @@ type Schema struct {
 	Examples    []interface{}
 	Deprecated  bool
 
+	// custom error messages. nil if errorMessage is not specified.
+	ErrorMessage   *ErrorMessage
+	translator     Translator
+	maxDepth       int  // Compiler.MaxValidationDepth
+	marshalUnknown bool // Compiler.MarshalUnknownTypes
+	useFloat       bool // !Compiler.UseNumber
+	trackPositions bool // Compiler.TrackPositions
+
 	// user defined extensions
 	Extensions map[string]ExtSchema
 }
@@ -108,10 +135,67 @@ func (s *Schema) String() string {
 	return s.Location
 }
 
-func newSchema(url, floc string, draft *Draft, doc interface{}) *Schema {
+// ID returns the base uri of s. It is the resolved '$id' of the nearest
+// schema resource enclosing s, or the url of the document s is loaded
+// from, if none declares '$id'. For example, for location
+// "http://example.com/person.json#/properties/name" it returns
+// "http://example.com/person.json".
+//
+// Anonymous schemas compiled by CompileReader have synthetic base uri
+// "inline:///schema.json", unless they declare '$id'.
+//
+// The draft of s is available as s.Draft.
+func (s *Schema) ID() string {
+	return s.url()
+}
+
+// Trivial tells whether s passes or fails every instance, irrespective of
+// its value. ok is true, if s is boolean schema, or schema without any
+// keyword that can fail validation, such as {} or schema with only
+// annotations. value is the result of validating any instance against s.
+// ok is false for other schemas.
+//
+// Subschemas are not inspected, so schema like {"not": false} or
+// {"$ref": "#/$defs/empty"} is not detected as trivial.
+//
+// The method is not named Always, since s.Always is the field holding
+// the value of boolean schema.
+func (s *Schema) Trivial() (value bool, ok bool) {
+	if s.Always != nil {
+		return *s.Always, true
+	}
+	hasFormat := (s.format != nil || s.formatDetail != nil) && !s.formatWarn
+	switch {
+	case hasFormat, s.Ref != nil, s.RecursiveRef != nil, s.DynamicRef != nil,
+		len(s.Types) > 0, s.Constant != nil, s.Enum != nil, s.Not != nil,
+		len(s.AllOf) > 0, len(s.AnyOf) > 0, len(s.OneOf) > 0, s.If != nil:
+		return false, false
+	case s.MinProperties != -1, s.MaxProperties != -1, len(s.Required) > 0,
+		len(s.Properties) > 0, s.PropertyNames != nil, s.RegexProperties,
+		len(s.PatternProperties) > 0, s.AdditionalProperties != nil,
+		len(s.Dependencies) > 0, len(s.DependentRequired) > 0,
+		len(s.DependentSchemas) > 0, s.UnevaluatedProperties != nil:
+		return false, false
+	case s.MinItems != -1, s.MaxItems != -1, s.UniqueItems, s.Items != nil,
+		s.AdditionalItems != nil, len(s.PrefixItems) > 0, s.Items2020 != nil,
+		s.Contains != nil, s.UnevaluatedItems != nil:
+		return false, false
+	case s.MinLength != -1, s.MaxLength != -1, s.Pattern != nil,
+		s.decoder != nil, s.mediaType != nil:
+		return false, false
+	case s.Minimum != nil, s.ExclusiveMinimum != nil, s.Maximum != nil,
+		s.ExclusiveMaximum != nil, s.MultipleOf != nil:
+		return false, false
+	case len(s.Extensions) > 0:
+		return false, false
+	}
+	return true, true
+}
+
+func newSchema(loc string, draft *Draft, doc interface{}) *Schema {
 	// fill with default values
 	s := &Schema{
-		Location:      url + floc,
+		Location:      loc,
 		Draft:         draft,
 		MinProperties: -1,
 		MaxProperties: -1,
@@ -161,91 +245,436 @@ func (s *Schema) hasVocab(name string) bool {
 // the v must be the raw json value. for number precision
 // unmarshal with json.UseNumber().
 //
+// json value is one of nil, bool, string, json.Number, go int, uint and
+// float types, []interface{} and map[string]interface{}, nested to any depth.
+// So hand-built documents with int leaves are accepted, and numbers of any
+// of these types compare equal by value. Other types such as map[string]int,
+// and NaN or infinite floats, are not json values, unless compiled with
+// Compiler.MarshalUnknownTypes.
+//
 // returns *ValidationError if v does not confirm with schema s.
 // returns InfiniteLoopError if it detects loop during validation.
-// returns InvalidJSONTypeError if it detects any non json value in v.
+// returns InvalidJSONTypeError if v has any non json value.
 func (s *Schema) Validate(v interface{}) (err error) {
 	return s.validateValue(v, "")
 }
 
-func (s *Schema) validateValue(v interface{}, vloc string) (err error) {
+// ValidateBytes decodes json document b and validates it against
+// the json-schema s, like Validate.
+//
+// numbers in b are decoded as json.Number, so there is no loss of precision,
+// unless s is compiled with Compiler.UseNumber false.
+// returns error if b is not valid json.
+func (s *Schema) ValidateBytes(b []byte) error {
+	v, p, err := s.decodePositions(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return s.validateDecoded(v, p)
+}
+
+// ValidateFile reads json document from file at given path and validates
+// it against the json-schema s, like ValidateBytes. The file is closed
+// before returning.
+//
+// if file cannot be opened, the returned error wraps the os error.
+func (s *Schema) ValidateFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("jsonschema: cannot open document: %w", err)
+	}
+	defer f.Close()
+	v, p, err := s.decodePositions(f)
+	if err != nil {
+		return err
+	}
+	return s.validateDecoded(v, p)
+}
+
+// ValidateN decodes json document from r and validates it against
+// the json-schema s, like ValidateBytes, but reports at most maxErrors
+// leaf errors. This keeps the error bounded for deeply invalid documents.
+//
+// Once maxErrors leaf errors are collected, remaining subschemas are not
+// applied, except those whose outcome decides validity of the document.
+// If errors may have been omitted, Truncated is set on the returned
+// *ValidationError. maxErrors <= 0 means no limit.
+func (s *Schema) ValidateN(r io.Reader, maxErrors int) error {
+	v, p, err := s.decodePositions(r)
+	if err != nil {
+		return err
+	}
+	vd := &validator{maxErrors: maxErrors}
+	_, err = s.validateWith(vd, v, "")
+	if ve, ok := err.(*ValidationError); ok {
+		if maxErrors > 0 {
+			n := maxErrors
+			ve.Truncated = ve.truncate(&n) || vd.truncated
+		}
+		if p != nil {
+			p.fill(ve)
+		}
+	}
+	return err
+}
+
+// ValidateAt decodes json document from r and validates it against
+// the subschema of s at given json-pointer, like ValidateBytes.
+//
+// pointer is resolved as in Subschema, so it may select a definition like
+// "#/$defs/user". If it does not resolve to a subschema in s, that error is
+// returned without reading r.
+func (s *Schema) ValidateAt(pointer string, r io.Reader) error {
+	sch, err := s.Subschema(pointer)
+	if err != nil {
+		return err
+	}
+	v, p, err := s.decodePositions(r)
+	if err != nil {
+		return err
+	}
+	return sch.validateDecoded(v, p)
+}
+
+// ValidateAll decodes json document from r and validates it against each of
+// the given schemas, like allOf of those schemas. r is read once.
+//
+// If the document does not confirm with some schemas, it returns *ValidationError
+// with message msg.Schemas listing those schemas, whose causes are the errors of
+// those schemas. It has no keyword location, since it is not reported by any
+// schema. Other errors, such as InfiniteLoopError, are returned as is. The
+// document is decoded as specified by the first schema, including
+// Compiler.TrackPositions.
+func ValidateAll(r io.Reader, schemas ...*Schema) error {
+	if len(schemas) == 0 {
+		_, err := unmarshal(r)
+		return err
+	}
+	v, p, err := schemas[0].decodePositions(r)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	var causes []error
+	for _, s := range schemas {
+		if err := s.Validate(v); err != nil {
+			if _, ok := err.(*ValidationError); !ok {
+				return err
+			}
+			failed = append(failed, s.Location)
+			causes = append(causes, err)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	ve := &ValidationError{
+		InstanceValue: v,
+		Message:       msg.Schemas{Got: failed},
+	}
+	ve.add(causes...)
+	if p != nil {
+		p.fill(ve)
+	}
+	return ve
+}
+
+// decode decodes json document to be validated from r.
+func (s *Schema) decode(r io.Reader) (interface{}, error) {
+	return decodeJSON(r, !s.useFloat)
+}
+
+// IsValid decodes json document from r and tells whether it is valid against
+// the json-schema s. It returns false, if r does not have valid json.
+//
+// Unlike ValidateBytes, it stops at the first failure and does not report why
+// the document is not valid. So it is faster for documents that are not valid.
+func (s *Schema) IsValid(r io.Reader) bool {
+	v, err := s.decode(r)
+	if err != nil {
+		return false
+	}
+	_, err = s.validateWith(&validator{failFast: true}, v, "")
+	return err == nil
+}
+
+// ValidateStream validates newline-delimited json documents read from r,
+// each against the json-schema s, like ValidateBytes.
+//
+// It returns channel of errors, which is closed after r is fully read.
+// For each document that is not valid json or does not confirm with schema s,
+// *RecordError is sent. Such document does not stop validation of further
+// documents. Blank lines are ignored. If reading r fails, that error is sent
+// and the channel is closed. The caller must receive from the channel until
+// it is closed.
+func (s *Schema) ValidateStream(r io.Reader) <-chan error {
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		br := bufio.NewReader(r)
+		for line := 1; ; line++ {
+			b, err := br.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				errs <- err
+				return
+			}
+			if len(bytes.TrimSpace(b)) > 0 {
+				if err := s.ValidateBytes(b); err != nil {
+					errs <- &RecordError{line, err}
+				}
+			}
+			if err == io.EOF {
+				return
+			}
+		}
+	}()
+	return errs
+}
+
+// ValidateContext validates given doc, against the json-schema s, like Validate.
+//
+// ctx is checked before validating each array item and object property.
+// If ctx is done, validation is aborted and ctx.Err() is returned.
+func (s *Schema) ValidateContext(ctx context.Context, v interface{}) error {
+	_, err := s.validateWith(&validator{ctx: ctx}, v, "")
+	return err
+}
+
+// ValidateWithAnnotations validates given doc, against the json-schema s
+// and returns annotations collected during validation, grouped by instance location.
+//
+// Annotations from subschemas that failed validation are dropped. If v does not
+// confirm with schema s, no annotations are returned.
+//
+// Annotations title, description, default, readOnly, writeOnly, examples and
+// deprecated are collected only if s is compiled with Compiler.ExtractAnnotations.
+func (s *Schema) ValidateWithAnnotations(v interface{}) (Annotations, error) {
+	result, err := s.validateWith(&validator{annotations: true}, v, "")
+	if err != nil {
+		return nil, err
+	}
+	annotations := make(Annotations)
+	for _, a := range result.annotations {
+		annotations[a.InstanceLocation] = append(annotations[a.InstanceLocation], a)
+	}
+	return annotations, nil
+}
+
+// ValidateAndApplyDefaults validates given doc, against the json-schema s
+// and on success fills missing object properties with their default values.
+//
+// Only defaults of subschemas under "properties" are applied, following their
+// "$ref" if needed. Properties that are present, even with null value, are not
+// overwritten. Defaults of array items or of doc itself are not applied.
+// Defaults from subschemas that failed validation are ignored.
+//
+// doc is modified in place and returned. The defaults are captured only if
+// s is compiled with Compiler.ExtractAnnotations.
+func (s *Schema) ValidateAndApplyDefaults(doc interface{}) (interface{}, error) {
+	result, err := s.validateWith(&validator{defaults: true}, doc, "")
+	if err != nil {
+		return doc, err
+	}
+	for _, d := range result.defaults {
+		if _, ok := d.obj[d.pname]; !ok {
+			d.obj[d.pname] = deepCopy(d.value)
+		}
+	}
+	return doc, nil
+}
+
+// ValidateWithResult validates given doc, against the json-schema s
+// and returns the result of successful validation.
+//
+// Result records which subschema of each oneOf and anyOf matched, for example
+// to decode a tagged union into the right go type. For anyOf, the first matching
+// subschema is recorded. Branches of subschemas that failed validation are dropped.
+// Result also has the format violations, if s is compiled with FormatWarn.
+// If v does not confirm with schema s, nil Result is returned.
+func (s *Schema) ValidateWithResult(v interface{}) (*Result, error) {
+	result, err := s.validateWith(&validator{branches: true}, v, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Branches: result.branches, Warnings: result.warnings}, nil
+}
+
+// ValidateWrite validates given doc, against the json-schema s, like Validate.
+// doc is treated as value being written, for example http request body.
+// So values of subschemas with "readOnly": true are rejected.
+//
+// readOnly is captured only if s is compiled with Compiler.ExtractAnnotations.
+func (s *Schema) ValidateWrite(doc interface{}) error {
+	_, err := s.validateWith(&validator{readOnly: true}, doc, "")
+	return err
+}
+
+// ValidateRead validates given doc, against the json-schema s, like Validate.
+// doc is treated as value being read, for example http response body.
+// So values of subschemas with "writeOnly": true are rejected.
+//
+// writeOnly is captured only if s is compiled with Compiler.ExtractAnnotations.
+func (s *Schema) ValidateRead(doc interface{}) error {
+	_, err := s.validateWith(&validator{writeOnly: true}, doc, "")
+	return err
+}
+
+func (s *Schema) validateValue(v interface{}, vloc string) error {
+	_, err := s.validateWith(&validator{}, v, vloc)
+	return err
+}
+
+func (s *Schema) validateWith(vd *validator, v interface{}, vloc string) (result validationResult, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			switch r := r.(type) {
-			case InfiniteLoopError, InvalidJSONTypeError:
+			case InfiniteLoopError, InvalidJSONTypeError, DepthError, failFastError:
 				err = r.(error)
+			case contextError:
+				err = r.err
 			default:
 				panic(r)
 			}
 		}
 	}()
-	if _, err := s.validate(nil, 0, "", v, vloc); err != nil {
+	if vd.maxDepth == 0 {
+		vd.maxDepth = s.maxDepth
+	}
+	vd.speculative, vd.uneval, vd.errors = 0, 0, 0
+	if s.marshalUnknown {
+		if v, err = marshalUnknown(v); err != nil {
+			return result, err
+		}
+	} else if f := findNonFinite(v); f != nil {
+		// rejected up front, since keywords may never look at it
+		return result, invalidJSONType(f)
+	}
+	if vd.scope == nil {
+		b := bufferPool.Get().(*buffers)
+		defer func() {
+			b.loc = vd.loc[:0]
+			vd.scope, vd.loc = nil, nil
+			bufferPool.Put(b)
+		}()
+		vd.scope, vd.loc = b.scope, b.loc
+	}
+	vd.loc = append(vd.loc[:0], vloc...)
+	if result, err = s.validate(vd, vd.scope[:0], 0, "", "", v); err != nil {
 		ve := ValidationError{
 			KeywordLocation:         "",
 			AbsoluteKeywordLocation: s.Location,
 			InstanceLocation:        vloc,
+			InstanceValue:           v,
 			Message:                 msg.Schema{Want: s.Location},
+			translator:              s.translator,
 		}
-		return ve.causes(err)
+		return result, ve.causes(err)
 	}
-	return nil
+	return result, nil
 }
 
 // validate validates given value v with this schema.
-func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interface{}, vloc string) (result validationResult, err error) {
-	validationError := func(keywordPath string, msg fmt.Stringer) *ValidationError {
+//
+// spath is the path to s from its parent schema, followed by unescaped token
+// stok, if not empty. instance location of v is held in vd.loc.
+func (s *Schema) validate(vd *validator, scope []schemaRef, vscope int, spath, stok string, v interface{}) (result validationResult, err error) {
+	newError := func(keywordPath string, msg fmt.Stringer) *ValidationError {
 		return &ValidationError{
 			KeywordLocation:         keywordLocation(scope, keywordPath),
 			AbsoluteKeywordLocation: joinPtr(s.Location, keywordPath),
-			InstanceLocation:        vloc,
+			InstanceLocation:        vd.vloc(),
+			InstanceValue:           v,
 			Message:                 msg,
+			translator:              s.translator,
 		}
 	}
 
-	sref := schemaRef{spath, s, false}
+	// validationError returns the error of a failing keyword, that has no
+	// causes. errors wrapping causes are created with newError, so that only
+	// leaf errors are counted by vd.
+	validationError := func(keywordPath string, msg fmt.Stringer) *ValidationError {
+		vd.leaf()
+		return newError(keywordPath, msg)
+	}
+
+	sref := schemaRef{spath, stok, s, false}
 	if err := checkLoop(scope[len(scope)-vscope:], sref); err != nil {
 		panic(err)
 	}
+	if vd.maxDepth > 0 && len(scope) >= vd.maxDepth {
+		panic(DepthError(keywordLocation(append(scope, sref), "")))
+	}
 	scope = append(scope, sref)
 	vscope++
 
+	if s.ErrorMessage != nil {
+		nerrors := vd.errors
+		defer func() {
+			if err != nil {
+				err = s.ErrorMessage.apply(err.(*ValidationError), keywordLocation(scope, ""), newError)
+				if vd.maxErrors > 0 && vd.speculative == 0 {
+					// errors replaced by custom message are not reported
+					vd.errors = nerrors + err.(*ValidationError).leaves()
+				}
+			}
+		}()
+	}
+
 	// populate result
+	if s.UnevaluatedProperties != nil || s.UnevaluatedItems != nil {
+		vd.uneval++
+		defer func() { vd.uneval-- }()
+	}
 	switch v := v.(type) {
 	case map[string]interface{}:
-		result.unevalProps = make(map[string]struct{})
-		for pname := range v {
-			result.unevalProps[pname] = struct{}{}
+		if vd.uneval > 0 || (s.AdditionalProperties != nil && len(s.PatternProperties) > 0) {
+			result.unevalProps = make(map[string]struct{}, len(v))
+			for pname := range v {
+				result.unevalProps[pname] = struct{}{}
+			}
 		}
 	case []interface{}:
-		result.unevalItems = make(map[int]struct{})
-		for i := range v {
-			result.unevalItems[i] = struct{}{}
+		if vd.uneval > 0 {
+			result.unevalItems = make(map[int]struct{}, len(v))
+			for i := range v {
+				result.unevalItems[i] = struct{}{}
+			}
 		}
 	}
 
-	validate := func(sch *Schema, schPath string, v interface{}, vpath string) error {
-		vloc := vloc
-		if vpath != "" {
-			vloc += "/" + vpath
+	validate := func(sch *Schema, schPath, schTok string, v interface{}, vpath string) error {
+		if vd.skip() {
+			return nil
+		}
+		if vd.ctx != nil {
+			if err := vd.ctx.Err(); err != nil {
+				panic(contextError{err})
+			}
+		}
+		n := vd.push(vpath)
+		// evaluated properties and items of child are not tracked
+		uneval := vd.uneval
+		vd.uneval = 0
+		vr, err := sch.validate(vd, scope, 0, schPath, schTok, v)
+		vd.uneval = uneval
+		vd.loc = vd.loc[:n]
+		if err == nil {
+			result.merge(vr)
+		} else {
+			vd.failed()
 		}
-		_, err := sch.validate(scope, 0, schPath, v, vloc)
 		return err
 	}
 
-	validateInplace := func(sch *Schema, schPath string) error {
-		vr, err := sch.validate(scope, vscope, schPath, v, vloc)
+	validateInplace := func(sch *Schema, schPath, schTok string) error {
+		if vd.skip() {
+			return nil
+		}
+		vr, err := sch.validate(vd, scope, vscope, schPath, schTok, v)
 		if err == nil {
-			// update result
-			for pname := range result.unevalProps {
-				if _, ok := vr.unevalProps[pname]; !ok {
-					delete(result.unevalProps, pname)
-				}
-			}
-			for i := range result.unevalItems {
-				if _, ok := vr.unevalItems[i]; !ok {
-					delete(result.unevalItems, i)
-				}
-			}
+			result.mergeInplace(vr)
+		} else {
+			vd.failed()
 		}
 		return err
 	}
@@ -265,8 +694,7 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 				matched = true
 				break
 			} else if t == "integer" && vType == "number" {
-				num, _ := new(big.Rat).SetString(fmt.Sprint(v))
-				if num.IsInt() {
+				if isInteger(v) {
 					matched = true
 					break
 				}
@@ -279,6 +707,13 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 
 	var errors []error
 
+	if vd.readOnly && s.ReadOnly {
+		errors = append(errors, validationError("readOnly", msg.ReadOnly{}))
+	}
+	if vd.writeOnly && s.WriteOnly {
+		errors = append(errors, validationError("writeOnly", msg.WriteOnly{}))
+	}
+
 	if len(s.Constant) > 0 {
 		if !equals(v, s.Constant[0]) {
 			errors = append(errors, validationError("const", msg.Const{Got: v, Want: s.Constant[0]}))
@@ -298,8 +733,26 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 		}
 	}
 
-	if s.format != nil && !s.format(v) {
-		errors = append(errors, validationError("format", msg.Format{Got: v, Want: s.Format}))
+	if s.format != nil || s.formatDetail != nil {
+		var failed bool
+		var detail string
+		if f, ok := vd.formats[s.Format]; ok {
+			failed = !f(v)
+		} else if s.formatDetail != nil {
+			if err := s.formatDetail(v); err != nil {
+				failed, detail = true, err.Error()
+			}
+		} else {
+			failed = !s.format(v)
+		}
+		if failed {
+			m := msg.Format{Got: v, Want: s.Format, Detail: detail}
+			if s.formatWarn {
+				result.warnings = append(result.warnings, validationError("format", m))
+			} else {
+				errors = append(errors, validationError("format", m))
+			}
+		}
 	}
 
 	switch v := v.(type) {
@@ -325,16 +778,26 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 		for pname, sch := range s.Properties {
 			if pvalue, ok := v[pname]; ok {
 				delete(result.unevalProps, pname)
-				if err := validate(sch, "properties/"+escape(pname), pvalue, escape(pname)); err != nil {
+				if err := validate(sch, "properties", pname, pvalue, escapePtr(pname)); err != nil {
 					errors = append(errors, err)
 				}
+			} else if vd.defaults {
+				if d := sch.defaultValue(); d != nil {
+					result.defaults = append(result.defaults, propDefault{v, pname, d})
+				}
 			}
 		}
 
 		if s.PropertyNames != nil {
 			for pname := range v {
-				if err := validate(s.PropertyNames, "propertyNames", pname, escape(pname)); err != nil {
-					errors = append(errors, err)
+				// annotations of propertyNames are not retained
+				n := vd.push(escapePtr(pname))
+				_, err := s.PropertyNames.validate(vd, scope, 0, "propertyNames", "", pname)
+				vd.loc = vd.loc[:n]
+				if err != nil {
+					ve := newError("propertyNames", msg.PropertyNames{Got: pname})
+					ve.InstanceLocation, ve.InstanceValue = vd.vloc()+"/"+escapePtr(pname), pname
+					errors = append(errors, ve.causes(err))
 				}
 			}
 		}
@@ -350,24 +813,44 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 			for pname, pvalue := range v {
 				if pattern.MatchString(pname) {
 					delete(result.unevalProps, pname)
-					if err := validate(sch, "patternProperties/"+escape(pattern.String()), pvalue, escape(pname)); err != nil {
-						errors = append(errors, err)
+					if err := validate(sch, "patternProperties", pattern.String(), pvalue, escapePtr(pname)); err != nil {
+						kw := "patternProperties/" + escape(pattern.String())
+						ve := newError(kw, msg.PatternProperties{Got: pname, Want: pattern.String()})
+						ve.InstanceLocation, ve.InstanceValue = vd.vloc()+"/"+escapePtr(pname), pvalue
+						errors = append(errors, ve.causes(err))
 					}
 				}
 			}
 		}
 		if s.AdditionalProperties != nil {
+			// properties not evaluated by properties and patternProperties
+			var pnames []string
+			if result.unevalProps != nil {
+				pnames = result.unevalPnames()
+			} else {
+				for pname := range v {
+					if _, ok := s.Properties[pname]; !ok {
+						pnames = append(pnames, pname)
+					}
+				}
+				sort.Strings(pnames)
+			}
 			if allowed, ok := s.AdditionalProperties.(bool); ok {
-				if !allowed && len(result.unevalProps) > 0 {
-					errors = append(errors, validationError("additionalProperties", msg.AdditionalProperties{Got: result.unevalPnames()}))
+				if !allowed && len(pnames) > 0 {
+					ve := newError("additionalProperties", msg.AdditionalProperties{Got: pnames})
+					for _, pname := range pnames {
+						// one cause per property, located at the property itself
+						cause := validationError("additionalProperties", msg.AdditionalProperties{Got: []string{pname}})
+						cause.InstanceLocation, cause.InstanceValue = vd.vloc()+"/"+escapePtr(pname), v[pname]
+						ve.add(cause)
+					}
+					errors = append(errors, ve)
 				}
 			} else {
 				schema := s.AdditionalProperties.(*Schema)
-				for pname := range result.unevalProps {
-					if pvalue, ok := v[pname]; ok {
-						if err := validate(schema, "additionalProperties", pvalue, escape(pname)); err != nil {
-							errors = append(errors, err)
-						}
+				for _, pname := range pnames {
+					if err := validate(schema, "additionalProperties", "", v[pname], escapePtr(pname)); err != nil {
+						errors = append(errors, err)
 					}
 				}
 			}
@@ -377,8 +860,9 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 			if _, ok := v[dname]; ok {
 				switch dvalue := dvalue.(type) {
 				case *Schema:
-					if err := validateInplace(dvalue, "dependencies/"+escape(dname)); err != nil {
-						errors = append(errors, err)
+					kw := "dependencies/" + escape(dname)
+					if err := validateInplace(dvalue, kw, ""); err != nil {
+						errors = append(errors, newError(kw, msg.DependentSchemas{Got: dname}).causes(err))
 					}
 				case []string:
 					for i, pname := range dvalue {
@@ -400,8 +884,9 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 		}
 		for dname, sch := range s.DependentSchemas {
 			if _, ok := v[dname]; ok {
-				if err := validateInplace(sch, "dependentSchemas/"+escape(dname)); err != nil {
-					errors = append(errors, err)
+				kw := "dependentSchemas/" + escape(dname)
+				if err := validateInplace(sch, kw, ""); err != nil {
+					errors = append(errors, newError(kw, msg.DependentSchemas{Got: dname}).causes(err))
 				}
 			}
 		}
@@ -454,7 +939,7 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 		switch items := s.Items.(type) {
 		case *Schema:
 			for i, item := range v {
-				if err := validate(items, "items", item, strconv.Itoa(i)); err != nil {
+				if err := validate(items, "items", "", item, strconv.Itoa(i)); err != nil {
 					errors = append(errors, err)
 				}
 			}
@@ -463,12 +948,12 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 			for i, item := range v {
 				if i < len(items) {
 					delete(result.unevalItems, i)
-					if err := validate(items[i], "items/"+strconv.Itoa(i), item, strconv.Itoa(i)); err != nil {
+					if err := validate(items[i], "items", strconv.Itoa(i), item, strconv.Itoa(i)); err != nil {
 						errors = append(errors, err)
 					}
 				} else if sch, ok := s.AdditionalItems.(*Schema); ok {
 					delete(result.unevalItems, i)
-					if err := validate(sch, "additionalItems", item, strconv.Itoa(i)); err != nil {
+					if err := validate(sch, "additionalItems", "", item, strconv.Itoa(i)); err != nil {
 						errors = append(errors, err)
 					}
 				} else {
@@ -488,12 +973,12 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 		for i, item := range v {
 			if i < len(s.PrefixItems) {
 				delete(result.unevalItems, i)
-				if err := validate(s.PrefixItems[i], "prefixItems/"+strconv.Itoa(i), item, strconv.Itoa(i)); err != nil {
+				if err := validate(s.PrefixItems[i], "prefixItems", strconv.Itoa(i), item, strconv.Itoa(i)); err != nil {
 					errors = append(errors, err)
 				}
 			} else if s.Items2020 != nil {
 				delete(result.unevalItems, i)
-				if err := validate(s.Items2020, "items", item, strconv.Itoa(i)); err != nil {
+				if err := validate(s.Items2020, "items", "", item, strconv.Itoa(i)); err != nil {
 					errors = append(errors, err)
 				}
 			} else {
@@ -505,8 +990,9 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 		if s.Contains != nil && (s.MinContains != -1 || s.MaxContains != -1) {
 			var matched []int
 			var causes []error
+			vd.speculative++
 			for i, item := range v {
-				if err := validate(s.Contains, "contains", item, strconv.Itoa(i)); err != nil {
+				if err := validate(s.Contains, "contains", "", item, strconv.Itoa(i)); err != nil {
 					causes = append(causes, err)
 				} else {
 					matched = append(matched, i)
@@ -515,8 +1001,9 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 					}
 				}
 			}
+			vd.speculative--
 			if s.MinContains != -1 && len(matched) < s.MinContains {
-				errors = append(errors, validationError("minContains", msg.MinContains{Got: matched, Want: s.MinContains}).add(causes...))
+				errors = append(errors, newError("minContains", msg.MinContains{Got: matched, Want: s.MinContains}).add(causes...))
 			}
 			if s.MaxContains != -1 && len(matched) > s.MaxContains {
 				errors = append(errors, validationError("maxContains", msg.MaxContains{Got: matched, Want: s.MaxContains}))
@@ -564,7 +1051,7 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 				if err != nil {
 					errors = append(errors, validationError("contentSchema", msg.ContentSchema{Got: content}))
 				} else {
-					err := validate(s.ContentSchema, "contentSchema", contentJSON, "")
+					err := validate(s.ContentSchema, "contentSchema", "", contentJSON, "")
 					if err != nil {
 						errors = append(errors, err)
 					}
@@ -572,30 +1059,50 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 			}
 		}
 
-	case json.Number, float32, float64, int, int8, int32, int64, uint, uint8, uint32, uint64:
+	case json.Number, float32, float64, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 		// lazy convert to *big.Rat to avoid allocation
 		var numVal *big.Rat
 		num := func() *big.Rat {
 			if numVal == nil {
-				numVal, _ = new(big.Rat).SetString(fmt.Sprint(v))
+				numVal = rat(v)
 			}
 			return numVal
 		}
+		// integers are compared without conversion
+		i, isInt := intValue(v)
+		cmp := func(r *big.Rat) int {
+			if j, ok := ratInt(r); ok && isInt {
+				switch {
+				case i < j:
+					return -1
+				case i > j:
+					return 1
+				}
+				return 0
+			}
+			return num().Cmp(r)
+		}
 
-		if s.Minimum != nil && num().Cmp(s.Minimum) < 0 {
+		if s.Minimum != nil && cmp(s.Minimum) < 0 {
 			errors = append(errors, validationError("minimum", msg.Minimum{Got: v, Want: s.Minimum}))
 		}
-		if s.ExclusiveMinimum != nil && num().Cmp(s.ExclusiveMinimum) <= 0 {
+		if s.ExclusiveMinimum != nil && cmp(s.ExclusiveMinimum) <= 0 {
 			errors = append(errors, validationError("exclusiveMinimum", msg.ExclusiveMinimum{Got: v, Want: s.ExclusiveMinimum}))
 		}
-		if s.Maximum != nil && num().Cmp(s.Maximum) > 0 {
+		if s.Maximum != nil && cmp(s.Maximum) > 0 {
 			errors = append(errors, validationError("maximum", msg.Maximum{Got: v, Want: s.Maximum}))
 		}
-		if s.ExclusiveMaximum != nil && num().Cmp(s.ExclusiveMaximum) >= 0 {
+		if s.ExclusiveMaximum != nil && cmp(s.ExclusiveMaximum) >= 0 {
 			errors = append(errors, validationError("exclusiveMaximum", msg.ExclusiveMaximum{Got: v, Want: s.ExclusiveMaximum}))
 		}
 		if s.MultipleOf != nil {
-			if q := new(big.Rat).Quo(num(), s.MultipleOf); !q.IsInt() {
+			var ok bool
+			if j, jok := ratInt(s.MultipleOf); jok && isInt && j != 0 {
+				ok = i%j == 0
+			} else {
+				ok = new(big.Rat).Quo(num(), s.MultipleOf).IsInt()
+			}
+			if !ok {
 				errors = append(errors, validationError("multipleOf", msg.MultipleOf{Got: v, Want: s.MultipleOf}))
 			}
 		}
@@ -604,12 +1111,12 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 	// $ref + $recursiveRef + $dynamicRef
 	validateRef := func(sch *Schema, refPath string) error {
 		if sch != nil {
-			if err := validateInplace(sch, refPath); err != nil {
+			if err := validateInplace(sch, refPath, ""); err != nil {
 				var url = sch.Location
 				if s.url() == sch.url() {
 					url = sch.loc()
 				}
-				return validationError(refPath, msg.Schema{Want: url}).causes(err)
+				return newError(refPath, msg.Schema{Want: url}).causes(err)
 			}
 		}
 		return nil
@@ -654,44 +1161,56 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 		}
 	}
 
-	if s.Not != nil && validateInplace(s.Not, "not") == nil {
-		errors = append(errors, validationError("not", msg.Not{}))
+	if s.Not != nil {
+		vd.speculative++
+		err := validateInplace(s.Not, "not", "")
+		vd.speculative--
+		if err == nil {
+			errors = append(errors, validationError("not", msg.Not{}))
+		}
 	}
 
 	if len(s.AllOf) > 0 {
 		var failed []int
 		var causes []error
 		for i, sch := range s.AllOf {
-			if err := validateInplace(sch, "allOf/"+strconv.Itoa(i)); err != nil {
+			if err := validateInplace(sch, "allOf", strconv.Itoa(i)); err != nil {
 				failed = append(failed, i)
 				causes = append(causes, err)
 			}
 		}
 		if len(failed) > 0 {
-			errors = append(errors, validationError("allOf", msg.AllOf{Got: failed}).add(causes...))
+			errors = append(errors, newError("allOf", msg.AllOf{Got: failed}).add(causes...))
 		}
 	}
 
 	if len(s.AnyOf) > 0 {
-		matched := false
+		matched := -1
 		var causes []error
+		vd.speculative++
 		for i, sch := range s.AnyOf {
-			if err := validateInplace(sch, "anyOf/"+strconv.Itoa(i)); err == nil {
-				matched = true
+			if err := validateInplace(sch, "anyOf", strconv.Itoa(i)); err == nil {
+				if matched == -1 {
+					matched = i
+				}
 			} else {
 				causes = append(causes, err)
 			}
 		}
-		if !matched {
-			errors = append(errors, validationError("anyOf", msg.AnyOf{}).add(causes...))
+		vd.speculative--
+		if matched == -1 {
+			errors = append(errors, newError("anyOf", msg.AnyOf{}).add(causes...))
+		} else if vd.branches {
+			result.branches = append(result.branches, s.branch(scope, "anyOf", vd.vloc(), matched))
 		}
 	}
 
 	if len(s.OneOf) > 0 {
 		matched := -1
 		var causes []error
+		vd.speculative++
 		for i, sch := range s.OneOf {
-			if err := validateInplace(sch, "oneOf/"+strconv.Itoa(i)); err == nil {
+			if err := validateInplace(sch, "oneOf", strconv.Itoa(i)); err == nil {
 				if matched == -1 {
 					matched = i
 				} else {
@@ -702,26 +1221,31 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 				causes = append(causes, err)
 			}
 		}
+		vd.speculative--
 		if matched == -1 {
-			errors = append(errors, validationError("oneOf", msg.OneOf{}).add(causes...))
+			errors = append(errors, newError("oneOf", msg.OneOf{}).add(causes...))
+		} else if vd.branches {
+			result.branches = append(result.branches, s.branch(scope, "oneOf", vd.vloc(), matched))
 		}
 	}
 
 	// if + then + else
 	if s.If != nil {
-		err := validateInplace(s.If, "if")
+		vd.speculative++
+		err := validateInplace(s.If, "if", "")
+		vd.speculative--
 		// "if" leaves dynamic scope
 		scope[len(scope)-1].discard = true
 		if err == nil {
 			if s.Then != nil {
-				if err := validateInplace(s.Then, "then"); err != nil {
-					errors = append(errors, validationError("then", msg.Then{}).add(err))
+				if err := validateInplace(s.Then, "then", ""); err != nil {
+					errors = append(errors, newError("then", msg.Then{}).add(err))
 				}
 			}
 		} else {
 			if s.Else != nil {
-				if err := validateInplace(s.Else, "else"); err != nil {
-					errors = append(errors, validationError("else", msg.Else{}).add(err))
+				if err := validateInplace(s.Else, "else", ""); err != nil {
+					errors = append(errors, newError("else", msg.Else{}).add(err))
 				}
 			}
 		}
@@ -729,20 +1253,29 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 		scope[len(scope)-1].discard = false
 	}
 
-	for _, ext := range s.Extensions {
-		if err := ext.Validate(ValidationContext{result, validate, validateInplace, validationError}, v); err != nil {
-			errors = append(errors, err)
-		}
+	if len(s.Extensions) > 0 {
+		extResult := result
+		// extensions may apply subschemas whose failure is not theirs
+		vd.speculative++
+		errors = append(errors, s.validateExtensions(vd, scope, vscope, v, &extResult)...)
+		vd.speculative--
+		result = extResult
 	}
 
 	// unevaluatedProperties + unevaluatedItems
 	switch v := v.(type) {
 	case map[string]interface{}:
 		if s.UnevaluatedProperties != nil {
-			for pname := range result.unevalProps {
-				if pvalue, ok := v[pname]; ok {
-					if err := validate(s.UnevaluatedProperties, "unevaluatedProperties", pvalue, escape(pname)); err != nil {
-						errors = append(errors, err)
+			if always := s.UnevaluatedProperties.Always; always != nil && !*always {
+				if len(result.unevalProps) > 0 {
+					errors = append(errors, validationError("unevaluatedProperties", msg.UnevaluatedProperties{Got: result.unevalPnames()}))
+				}
+			} else {
+				for pname := range result.unevalProps {
+					if pvalue, ok := v[pname]; ok {
+						if err := validate(s.UnevaluatedProperties, "unevaluatedProperties", "", pvalue, escapePtr(pname)); err != nil {
+							errors = append(errors, err)
+						}
 					}
 				}
 			}
@@ -750,9 +1283,15 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 		}
 	case []interface{}:
 		if s.UnevaluatedItems != nil {
-			for i := range result.unevalItems {
-				if err := validate(s.UnevaluatedItems, "unevaluatedItems", v[i], strconv.Itoa(i)); err != nil {
-					errors = append(errors, err)
+			if always := s.UnevaluatedItems.Always; always != nil && !*always {
+				if len(result.unevalItems) > 0 {
+					errors = append(errors, validationError("unevaluatedItems", msg.UnevaluatedItems{Got: result.unevalIndexes()}))
+				}
+			} else {
+				for i := range result.unevalItems {
+					if err := validate(s.UnevaluatedItems, "unevaluatedItems", "", v[i], strconv.Itoa(i)); err != nil {
+						errors = append(errors, err)
+					}
 				}
 			}
 			result.unevalItems = nil
@@ -761,17 +1300,243 @@ func (s *Schema) validate(scope []schemaRef, vscope int, spath string, v interfa
 
 	switch len(errors) {
 	case 0:
+		if vd.annotations {
+			result.annotations = append(result.annotations, s.collectAnnotations(scope, vd.vloc())...)
+		}
 		return result, nil
 	case 1:
 		return result, errors[0]
 	default:
-		return result, validationError("", msg.Empty{}).add(errors...) // empty message, used just for wrapping
+		sortErrors(errors)
+		return result, newError("", msg.Empty{}).add(errors...) // empty message, used just for wrapping
+	}
+}
+
+// sortErrors sorts errors by instance location, then by keyword location.
+// So that order of errors does not depend on iteration order of maps.
+func sortErrors(errors []error) {
+	sort.SliceStable(errors, func(i, j int) bool {
+		ei, ok1 := errors[i].(*ValidationError)
+		ej, ok2 := errors[j].(*ValidationError)
+		if !ok1 || !ok2 {
+			return false
+		}
+		if c := comparePtr(ei.InstanceLocation, ej.InstanceLocation); c != 0 {
+			return c < 0
+		}
+		return comparePtr(ei.KeywordLocation, ej.KeywordLocation) < 0
+	})
+}
+
+// comparePtr compares json-pointers p1 and p2, token by token.
+// tokens that are array indexes are compared numerically, so "/2" < "/10".
+func comparePtr(p1, p2 string) int {
+	for p1 != "" && p2 != "" {
+		var t1, t2 string
+		t1, p1 = nextToken(p1)
+		t2, p2 = nextToken(p2)
+		if t1 == t2 {
+			continue
+		}
+		i1, err1 := strconv.Atoi(t1)
+		i2, err2 := strconv.Atoi(t2)
+		if err1 == nil && err2 == nil && i1 != i2 {
+			if i1 < i2 {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(t1, t2)
+	}
+	return strings.Compare(p1, p2)
+}
+
+// nextToken returns the first token of json-pointer ptr,
+// and the rest of ptr.
+func nextToken(ptr string) (string, string) {
+	ptr = strings.TrimPrefix(ptr, "/")
+	if i := strings.IndexByte(ptr, '/'); i != -1 {
+		return ptr[:i], ptr[i:]
 	}
+	return ptr, ""
+}
+
+// validator captures the options of a single validation.
+type validator struct {
+	ctx         context.Context // nil, if validation cannot be cancelled
+	annotations bool            // whether annotations are collected
+	defaults    bool            // whether defaults of missing properties are collected
+	branches    bool            // whether matched branches of oneOf and anyOf are collected
+	maxDepth    int             // max nesting of schemas applied. zero means no limit
+	readOnly    bool            // whether readOnly values are rejected
+	writeOnly   bool            // whether writeOnly values are rejected
+
+	// formats overriding the ones asserted by schema, used when
+	// validating against metaschema. nil if none.
+	formats map[string]func(interface{}) bool
+
+	failFast    bool // whether to abort at first failure, without reporting it
+	speculative int  // number of subschemas being applied, whose failure is not failure of document
+
+	maxErrors int  // max leaf errors collected, before remaining subschemas are skipped. zero means no limit
+	errors    int  // number of leaf errors collected, that are failure of document
+	truncated bool // whether any subschema is skipped, because of maxErrors
+
+	// uneval is the number of schemas with unevaluatedProperties or unevaluatedItems,
+	// being applied to current json value. Evaluated properties and items are tracked
+	// only if it is non-zero, or the schema has additionalProperties.
+	uneval int
+
+	// scope is the buffer for schemas being applied. Its capacity is reused,
+	// so that nested schemas do not allocate scope. nil if not allocated yet.
+	scope []schemaRef
+
+	// loc is the instance location of json value being validated. Tokens are
+	// appended when descending into children and truncated on return, so that
+	// locations are converted to string only when they are reported.
+	loc []byte
+}
+
+// failFastError is used to abort validation at first failure, when validator's failFast is set.
+type failFastError struct{}
+
+func (failFastError) Error() string {
+	return "jsonschema: validation failed"
+}
+
+// failed is called when a subschema fails. In failFast mode, it aborts
+// validation if that failure makes the document invalid.
+func (vd *validator) failed() {
+	if vd.failFast && vd.speculative == 0 {
+		panic(failFastError{})
+	}
+}
+
+// leaf is called when a leaf error is created. It counts the error
+// towards maxErrors, if that error is failure of document.
+func (vd *validator) leaf() {
+	if vd.speculative == 0 {
+		vd.errors++
+	}
+}
+
+// skip reports whether applying a subschema can be skipped, because
+// maxErrors leaf errors are already collected. Subschemas whose failure
+// is not failure of document are never skipped, since their outcome
+// matters.
+func (vd *validator) skip() bool {
+	if vd.maxErrors > 0 && vd.speculative == 0 && vd.errors >= vd.maxErrors {
+		vd.truncated = true
+		return true
+	}
+	return false
+}
+
+// push appends escaped json-pointer token tok to vd.loc, unless it is empty.
+// returns the length of vd.loc to be restored after validating the child.
+func (vd *validator) push(tok string) int {
+	n := len(vd.loc)
+	if tok != "" {
+		vd.loc = append(append(vd.loc, '/'), tok...)
+	}
+	return n
+}
+
+// vloc returns the instance location of json value being validated.
+func (vd *validator) vloc() string {
+	return string(vd.loc)
+}
+
+// buffers are the buffers of validator, that are reused across validations.
+type buffers struct {
+	scope []schemaRef
+	loc   []byte
+}
+
+// bufferPool pools the buffers of validators, not created by Schema.Validator.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &buffers{
+			scope: make([]schemaRef, 0, 32),
+			loc:   make([]byte, 0, 64),
+		}
+	},
+}
+
+// contextError is used to abort validation, when validator's ctx is done.
+type contextError struct {
+	err error
 }
 
 type validationResult struct {
 	unevalProps map[string]struct{}
 	unevalItems map[int]struct{}
+	annotations []Annotation
+	defaults    []propDefault
+	branches    []Branch
+	warnings    []*ValidationError
+}
+
+// merge merges the result of subschema applied to a child of json value.
+func (vr *validationResult) merge(r validationResult) {
+	vr.annotations = append(vr.annotations, r.annotations...)
+	vr.defaults = append(vr.defaults, r.defaults...)
+	vr.branches = append(vr.branches, r.branches...)
+	vr.warnings = append(vr.warnings, r.warnings...)
+}
+
+// mergeInplace merges the result of subschema applied to the same json value.
+func (vr *validationResult) mergeInplace(r validationResult) {
+	vr.merge(r)
+	for pname := range vr.unevalProps {
+		if _, ok := r.unevalProps[pname]; !ok {
+			delete(vr.unevalProps, pname)
+		}
+	}
+	for i := range vr.unevalItems {
+		if _, ok := r.unevalItems[i]; !ok {
+			delete(vr.unevalItems, i)
+		}
+	}
+}
+
+// propDefault captures default value of missing property pname in obj.
+type propDefault struct {
+	obj   map[string]interface{}
+	pname string
+	value interface{}
+}
+
+// defaultValue returns the default value of s, following its $ref.
+func (s *Schema) defaultValue() interface{} {
+	for s != nil {
+		if s.Default != nil {
+			return s.Default
+		}
+		s = s.Ref
+	}
+	return nil
+}
+
+// deepCopy returns a copy of json value v, which does not share
+// objects and arrays with v.
+func deepCopy(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for pname, pvalue := range v {
+			m[pname] = deepCopy(pvalue)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, item := range v {
+			arr[i] = deepCopy(item)
+		}
+		return arr
+	default:
+		return v
+	}
 }
 
 func (vr validationResult) unevalPnames() []string {
@@ -779,9 +1544,19 @@ func (vr validationResult) unevalPnames() []string {
 	for pname := range vr.unevalProps {
 		pnames = append(pnames, pname)
 	}
+	sort.Strings(pnames)
 	return pnames
 }
 
+func (vr validationResult) unevalIndexes() []int {
+	indexes := make([]int, 0, len(vr.unevalItems))
+	for i := range vr.unevalItems {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
 // jsonType returns the json type of given value v.
 //
 // It panics if the given value is not valid json value
@@ -791,7 +1566,7 @@ func jsonType(v interface{}) string {
 		return "null"
 	case bool:
 		return "boolean"
-	case json.Number, float32, float64, int, int8, int32, int64, uint, uint8, uint32, uint64:
+	case json.Number, float32, float64, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 		return "number"
 	case string:
 		return "string"
@@ -803,6 +1578,150 @@ func jsonType(v interface{}) string {
 	panic(InvalidJSONTypeError(fmt.Sprintf("%T", v)))
 }
 
+// marshalUnknown returns v as is, if it is json value. Otherwise it
+// returns v converted to json value using json.Marshal.
+func marshalUnknown(v interface{}) (interface{}, error) {
+	unknown := findUnknown(v)
+	if unknown == nil {
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, invalidJSONType(unknown)
+	}
+	return unmarshal(bytes.NewReader(b))
+}
+
+// invalidJSONType returns InvalidJSONTypeError for non json value v.
+// NaN and infinite floats are reported with their value.
+func invalidJSONType(v interface{}) InvalidJSONTypeError {
+	switch v.(type) {
+	case float32, float64:
+		return InvalidJSONTypeError(fmt.Sprintf("%T %v", v, v))
+	}
+	return InvalidJSONTypeError(fmt.Sprintf("%T", v))
+}
+
+// findUnknown returns the first value in v, that is not json value.
+// It returns nil, if there is no such value.
+func findUnknown(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, bool, json.Number, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, string:
+		return nil
+	case float32:
+		if f := float64(v); math.IsNaN(f) || math.IsInf(f, 0) {
+			return v
+		}
+		return nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return v
+		}
+		return nil
+	case []interface{}:
+		for _, item := range v {
+			if u := findUnknown(item); u != nil {
+				return u
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		for _, pvalue := range v {
+			if u := findUnknown(pvalue); u != nil {
+				return u
+			}
+		}
+		return nil
+	}
+	return v
+}
+
+// findNonFinite returns the first NaN or infinite float in v.
+// It returns nil, if there is no such value.
+func findNonFinite(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float32:
+		if f := float64(v); math.IsNaN(f) || math.IsInf(f, 0) {
+			return v
+		}
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return v
+		}
+	case []interface{}:
+		for _, item := range v {
+			if f := findNonFinite(item); f != nil {
+				return f
+			}
+		}
+	case map[string]interface{}:
+		for _, pvalue := range v {
+			if f := findNonFinite(pvalue); f != nil {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// isInteger tells whether json number v has zero fractional part.
+func isInteger(v interface{}) bool {
+	if _, ok := intValue(v); ok {
+		return true
+	}
+	return rat(v).IsInt()
+}
+
+// intValue returns json number v as int64, if it is an integer
+// that can be converted without loss.
+func intValue(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), true
+		}
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// ratInt returns r as int64, if it is an integer that fits.
+func ratInt(r *big.Rat) (int64, bool) {
+	if r.IsInt() && r.Num().IsInt64() {
+		return r.Num().Int64(), true
+	}
+	return 0, false
+}
+
+// rat returns json number v as *big.Rat. It panics with InvalidJSONTypeError,
+// if v is NaN or infinite float, which are not json numbers.
+func rat(v interface{}) *big.Rat {
+	num, ok := new(big.Rat).SetString(fmt.Sprint(v))
+	if !ok {
+		panic(InvalidJSONTypeError(fmt.Sprintf("%T %v", v, v)))
+	}
+	return num
+}
+
 // equals tells if given two json values are equal or not.
 func equals(v1, v2 interface{}) bool {
 	v1Type := jsonType(v1)
@@ -837,9 +1756,7 @@ func equals(v1, v2 interface{}) bool {
 		}
 		return true
 	case "number":
-		num1, _ := new(big.Rat).SetString(fmt.Sprint(v1))
-		num2, _ := new(big.Rat).SetString(fmt.Sprint(v2))
-		return num1.Cmp(num2) == 0
+		return rat(v1).Cmp(rat(v2)) == 0
 	default:
 		return v1 == v2
 	}
@@ -856,9 +1773,9 @@ func hash(v interface{}, h *maphash.Hash) {
 		} else {
 			h.WriteByte(0)
 		}
-	case json.Number, float32, float64, int, int8, int32, int64, uint, uint8, uint32, uint64:
+	case json.Number, float32, float64, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 		h.WriteByte(2)
-		num, _ := new(big.Rat).SetString(fmt.Sprint(v))
+		num := rat(v)
 		h.Write(num.Num().Bytes())
 		h.Write(num.Denom().Bytes())
 	case string:
@@ -887,9 +1804,13 @@ func hash(v interface{}, h *maphash.Hash) {
 	}
 }
 
-// escape converts given token to valid json-pointer token
+// escape converts given token to valid json-pointer token, that can be used in uri fragment
 func escape(token string) string {
+	return url.PathEscape(escapePtr(token))
+}
+
+// escapePtr converts given token to valid json-pointer token
+func escapePtr(token string) string {
 	token = strings.ReplaceAll(token, "~", "~0")
-	token = strings.ReplaceAll(token, "/", "~1")
-	return url.PathEscape(token)
+	return strings.ReplaceAll(token, "/", "~1")
 }
@@ -2,28 +2,41 @@ package jsonschema_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
 
-	"github.com/santhosh-tekuri/jsonschema/v5"
-	_ "github.com/santhosh-tekuri/jsonschema/v5/httploader"
+	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6"
+	_ "gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6/httploader"
+	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6/msg"
 )
 
 var skipTests = map[string]map[string][]string{
 	"TestDraft4/optional/zeroTerminatedFloats.json": {
-		"some languages do not distinguish between different types of numeric value": {}, // this behavior is changed in new drafts
+		// a number with zero fractional part, such as 1.0, is integer in all drafts. see TestIntegerType
+		"some languages do not distinguish between different types of numeric value": {},
 	},
 	"TestDraft4/optional/ecmascript-regex.json": {
 		"ECMA 262 \\s matches whitespace": {
@@ -172,25 +185,33 @@ func TestExtra(t *testing.T) {
 	t.Run("draft7", func(t *testing.T) {
 		testFolder(t, "testdata/tests/draft7", jsonschema.Draft7)
 	})
+	t.Run("draft2019", func(t *testing.T) {
+		testFolder(t, "testdata/tests/draft2019", jsonschema.Draft2019)
+	})
 	t.Run("draft2020", func(t *testing.T) {
 		testFolder(t, "testdata/tests/draft2020", jsonschema.Draft2020)
 	})
 }
 
 func TestMain(m *testing.M) {
-	server1 := &http.Server{Addr: "localhost:1234", Handler: http.FileServer(http.Dir("testdata/JSON-Schema-Test-Suite/remotes"))}
-	go func() {
-		if err := server1.ListenAndServe(); err != http.ErrServerClosed {
-			panic(err)
-		}
-	}()
-	server2 := &http.Server{Addr: "localhost:1235", Handler: http.FileServer(http.Dir("testdata/remotes"))}
+	serve("localhost:1234", "testdata/JSON-Schema-Test-Suite/remotes")
+	serve("localhost:1235", "testdata/remotes")
+	os.Exit(m.Run())
+}
+
+// serve starts file server for dir on addr. It returns only after
+// the listener is bound, so that tests do not race with server startup.
+func serve(addr, dir string) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	server := &http.Server{Handler: http.FileServer(http.Dir(dir))}
 	go func() {
-		if err := server2.ListenAndServe(); err != http.ErrServerClosed {
+		if err := server.Serve(l); err != http.ErrServerClosed {
 			panic(err)
 		}
 	}()
-	os.Exit(m.Run())
 }
 
 func testFolder(t *testing.T, folder string, draft *jsonschema.Draft) {
@@ -631,6 +652,122 @@ func TestNonStringFormat(t *testing.T) {
 	}
 }
 
+func TestCompilerFormats(t *testing.T) {
+	compile := func(formats map[string]func(interface{}) bool) *jsonschema.Schema {
+		c := jsonschema.NewCompiler()
+		c.AssertFormat = true
+		for name, f := range formats {
+			c.Formats[name] = f
+		}
+		if err := c.AddResource("schema.json", strings.NewReader(`{
+			"properties": {
+				"code": {"format": "code"},
+				"email": {"format": "email"}
+			}
+		}`)); err != nil {
+			t.Fatal(err)
+		}
+		return c.MustCompile("schema.json")
+	}
+	upper := compile(map[string]func(interface{}) bool{
+		"code": func(v interface{}) bool { s, ok := v.(string); return !ok || strings.ToUpper(s) == s },
+	})
+	lower := compile(map[string]func(interface{}) bool{
+		"code": func(v interface{}) bool { s, ok := v.(string); return !ok || strings.ToLower(s) == s },
+		// overrides the global email format
+		"email": func(v interface{}) bool { s, ok := v.(string); return !ok || strings.HasSuffix(s, "@example.com") },
+	})
+	tests := []struct {
+		sch      *jsonschema.Schema
+		instance string
+		valid    bool
+	}{
+		{upper, `{"code": "ABC"}`, true},
+		{upper, `{"code": "abc"}`, false},
+		{lower, `{"code": "abc"}`, true},
+		{lower, `{"code": "ABC"}`, false},
+		{upper, `{"email": "a@b.com"}`, true},
+		{upper, `{"email": "a"}`, false},
+		{lower, `{"email": "a@b.com"}`, false},
+		{lower, `{"email": "a@example.com"}`, true},
+	}
+	for i, test := range tests {
+		err := test.sch.Validate(decodeString(t, test.instance))
+		if test.valid != (err == nil) {
+			t.Errorf("#%d: %s: valid %t, got %v", i, test.instance, test.valid, err)
+		}
+	}
+	if _, ok := jsonschema.Formats["code"]; ok {
+		t.Error("global Formats must not be modified")
+	}
+}
+
+// ecmaRegexp supports ECMA 262 control escapes such as \cJ, which
+// golang's regexp does not.
+type ecmaRegexp struct {
+	*regexp.Regexp
+	src string
+}
+
+func (re ecmaRegexp) String() string {
+	return re.src
+}
+
+var ctrlEscape = regexp.MustCompile(`\\c[A-Za-z]`)
+
+func compileECMARegex(s string) (jsonschema.Regexp, error) {
+	translated := ctrlEscape.ReplaceAllStringFunc(s, func(m string) string {
+		return fmt.Sprintf(`\x%02X`, m[2]%32)
+	})
+	re, err := regexp.Compile(translated)
+	if err != nil {
+		return nil, err
+	}
+	return ecmaRegexp{re, s}, nil
+}
+
+func TestCompileRegex(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	c.CompileRegex = compileECMARegex
+	c.Formats["regex"] = func(v interface{}) bool {
+		s, ok := v.(string)
+		if !ok {
+			return true
+		}
+		_, err := compileECMARegex(s)
+		return err == nil
+	}
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"properties": {
+			"line": {"pattern": "^\\cJ$"}
+		},
+		"patternProperties": {
+			"^\\cIx$": {"type": "integer"}
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch := c.MustCompile("schema.json")
+	tests := []struct {
+		instance interface{}
+		valid    bool
+	}{
+		{map[string]interface{}{"line": "\n"}, true},
+		{map[string]interface{}{"line": "J"}, false},
+		{map[string]interface{}{"\tx": 1}, true},
+		{map[string]interface{}{"\tx": "1"}, false},
+	}
+	for i, test := range tests {
+		err := sch.Validate(test.instance)
+		if test.valid != (err == nil) {
+			t.Errorf("#%d: valid %t, got %v", i, test.valid, err)
+		}
+	}
+	if got, want := sch.Properties["line"].Pattern.String(), `^\cJ$`; got != want {
+		t.Errorf("pattern: got %q, want %q", got, want)
+	}
+}
+
 func TestCompiler_LoadURL(t *testing.T) {
 	const (
 		base   = `{ "type": "string" }`
@@ -724,6 +861,25 @@ func decodeReader(t *testing.T, r io.Reader) interface{} {
 	return doc
 }
 
+// leafError returns the error without causes, reached by following
+// the first cause of ve.
+func leafError(ve *jsonschema.ValidationError) *jsonschema.ValidationError {
+	for len(ve.Causes) > 0 {
+		ve = ve.Causes[0]
+	}
+	return ve
+}
+
+// causeAt returns the error at keyword location kloc, reached by following
+// the single cause of ve. If there is none, it returns the error where the
+// walk stopped.
+func causeAt(ve *jsonschema.ValidationError, kloc string) *jsonschema.ValidationError {
+	for ve.KeywordLocation != kloc && len(ve.Causes) == 1 {
+		ve = ve.Causes[0]
+	}
+	return ve
+}
+
 func Test_CompileTwice_Direct(t *testing.T) {
 	c := jsonschema.NewCompiler()
 	invalidSchema := `{"type": "abcd"}`
@@ -782,3 +938,4417 @@ func Test_CompileTwice_Indirect2(t *testing.T) {
 		t.Fatal("schema compilation must fail second time")
 	}
 }
+
+func TestUnevaluatedPropertiesError(t *testing.T) {
+	sch, err := jsonschema.CompileString("schema.json", `{
+		"$schema": "https://json-schema.org/draft/2019-09/schema",
+		"allOf": [{"properties": {"foo": true}}],
+		"unevaluatedProperties": false
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sch.Validate(decodeString(t, `{"foo": 1, "bar": 2}`))
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if got, want := err.Error(), "unevaluatedProperties 'bar' not allowed"; !strings.Contains(got, want) {
+		t.Errorf("got %q, want it to contain %q", got, want)
+	}
+}
+
+func TestUnevaluatedItemsError(t *testing.T) {
+	sch, err := jsonschema.CompileString("schema.json", `{
+		"prefixItems": [{"type": "string"}],
+		"unevaluatedItems": false
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sch.Validate(decodeString(t, `["a", 1, 2]`))
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if got, want := err.Error(), "unevaluatedItems at index 1 2 not allowed"; !strings.Contains(got, want) {
+		t.Errorf("got %q, want it to contain %q", got, want)
+	}
+}
+
+func TestContentErrorLocation(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	c.AssertContent = true
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"items": {
+			"properties": {
+				"data": {"contentEncoding": "base64", "contentMediaType": "application/json"}
+			}
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := c.Compile("schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		instance string
+		keyword  string
+	}{
+		{`[{"data": "eyJmb28iOiAiYmFyIn0K"}, {"data": "eyJmb28iOi%iYmFyIn0K"}]`, "/items/properties/data/contentEncoding"},
+		{`[{"data": "eyJmb28iOiAiYmFyIn0K"}, {"data": "ezp9Cg=="}]`, "/items/properties/data/contentMediaType"},
+	}
+	for _, test := range tests {
+		err := sch.Validate(decodeString(t, test.instance))
+		if err == nil {
+			t.Fatalf("%s: error expected", test.instance)
+		}
+		leaf := leafError(err.(*jsonschema.ValidationError))
+		if leaf.KeywordLocation != test.keyword {
+			t.Errorf("%s: keywordLocation: got %q, want %q", test.instance, leaf.KeywordLocation, test.keyword)
+		}
+		if leaf.InstanceLocation != "/1/data" {
+			t.Errorf("%s: instanceLocation: got %q, want %q", test.instance, leaf.InstanceLocation, "/1/data")
+		}
+	}
+}
+
+func TestCompilerDecoders(t *testing.T) {
+	schema := `{
+		"properties": {
+			"json": {"contentEncoding": "base64url", "contentMediaType": "application/json"},
+			"csv": {"contentEncoding": "base64url", "contentMediaType": "text/csv"}
+		}
+	}`
+	compile := func(register bool) *jsonschema.Schema {
+		c := jsonschema.NewCompiler()
+		c.AssertContent = true
+		if register {
+			c.Decoders["base64url"] = base64.RawURLEncoding.DecodeString
+			c.MediaTypes["text/csv"] = func(b []byte) error {
+				_, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+				return err
+			}
+		}
+		if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		return c.MustCompile("schema.json")
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	registered, unregistered := compile(true), compile(false)
+	tests := []struct {
+		sch      *jsonschema.Schema
+		instance interface{}
+		keyword  string
+	}{
+		{registered, map[string]interface{}{"json": enc([]byte(`{"a":"b"}`))}, ""},
+		{registered, map[string]interface{}{"json": "+/+/"}, "/properties/json/contentEncoding"},
+		{registered, map[string]interface{}{"json": enc([]byte(`{"a":`))}, "/properties/json/contentMediaType"},
+		{registered, map[string]interface{}{"csv": enc([]byte("a,b\nc,d\n"))}, ""},
+		{registered, map[string]interface{}{"csv": enc([]byte("a,b\nc\n"))}, "/properties/csv/contentMediaType"},
+		// base64url is not known to this compiler, so content is not asserted
+		{unregistered, map[string]interface{}{"json": "+/+/"}, ""},
+	}
+	for i, test := range tests {
+		err := test.sch.Validate(test.instance)
+		if test.keyword == "" {
+			if err != nil {
+				t.Errorf("#%d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("#%d: error expected", i)
+			continue
+		}
+		leaf := leafError(err.(*jsonschema.ValidationError))
+		if leaf.KeywordLocation != test.keyword {
+			t.Errorf("#%d: keywordLocation: got %q, want %q", i, leaf.KeywordLocation, test.keyword)
+		}
+	}
+	if _, ok := jsonschema.Decoders["base64url"]; ok {
+		t.Error("global Decoders must not be modified")
+	}
+}
+
+func TestAbsoluteKeywordLocation(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"properties": {
+			"a": {"$ref": "defs.json#/$defs/a"},
+			"b": {"$ref": "defs.json#/$defs/b"}
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddResource("defs.json", strings.NewReader(`{
+		"$id": "http://example.com/defs.json",
+		"$defs": {
+			"a": {"type": "string"},
+			"b": {
+				"$id": "b.json",
+				"properties": {"c": {"type": "integer"}}
+			}
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := c.Compile("schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		instance string
+		want     string
+	}{
+		{`{"a": 1}`, "http://example.com/defs.json#/$defs/a/type"},
+		{`{"b": {"c": "x"}}`, "http://example.com/b.json#/properties/c/type"},
+	}
+	for _, test := range tests {
+		err := sch.Validate(decodeString(t, test.instance))
+		if err == nil {
+			t.Fatalf("%s: error expected", test.instance)
+		}
+		leaf := leafError(err.(*jsonschema.ValidationError))
+		if leaf.AbsoluteKeywordLocation != test.want {
+			t.Errorf("%s: got %q, want %q", test.instance, leaf.AbsoluteKeywordLocation, test.want)
+		}
+	}
+}
+
+func TestAddResourceFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"schemas/main.json":           {Data: []byte(`{"properties": {"address": {"$ref": "./common/address.json"}}}`)},
+		"schemas/common/address.json": {Data: []byte(`{"required": ["city"], "properties": {"zip": {"$ref": "../zip.json"}}}`)},
+		"schemas/zip.json":            {Data: []byte(`{"type": "string"}`)},
+		"schemas/readme.txt":          {Data: []byte(`not json`)},
+	}
+	for _, base := range []string{"mem:///", "mem://app", "https://example.com/v1/"} {
+		t.Run(base, func(t *testing.T) {
+			c := jsonschema.NewCompiler()
+			if err := c.AddResourceFromFS(fsys, base, "schemas/*.json"); err != nil {
+				t.Fatal(err)
+			}
+			// urls do not depend on current directory
+			wd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chdir(t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = os.Chdir(wd) }()
+			if err := c.AddResourceFromFS(fsys, base, "schemas/common/*.json"); err != nil {
+				t.Fatal(err)
+			}
+			c.LoadURL = func(s string) (io.ReadCloser, error) {
+				return nil, fmt.Errorf("unexpected load %s", s)
+			}
+			if !strings.HasSuffix(base, "/") {
+				base += "/"
+			}
+			sch, err := c.Compile(base + "schemas/main.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sch.Validate(decodeString(t, `{"address": {"city": "x", "zip": "123"}}`)); err != nil {
+				t.Fatal(err)
+			}
+			for _, doc := range []string{`{"address": {}}`, `{"address": {"city": "x", "zip": 123}}`} {
+				if err := sch.Validate(decodeString(t, doc)); err == nil {
+					t.Errorf("%s: error expected", doc)
+				}
+			}
+			if _, err := c.Compile("schemas/main.json"); err == nil {
+				t.Error("must not be added relative to current directory")
+			}
+		})
+	}
+
+	if err := jsonschema.NewCompiler().AddResourceFromFS(fsys, "schemas", "schemas/*.json"); err == nil {
+		t.Error("relative base url: error expected")
+	}
+}
+
+func TestValidateWithAnnotations(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	c.ExtractAnnotations = true
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"title": "root",
+		"properties": {
+			"name": {"$ref": "#/$defs/name"},
+			"kind": {
+				"anyOf": [
+					{"type": "string", "description": "kind as string"},
+					{"type": "integer", "description": "kind as integer"}
+				]
+			},
+			"tags": {
+				"items": {"format": "hostname", "default": "localhost"}
+			}
+		},
+		"propertyNames": {"title": "property name"},
+		"$defs": {
+			"name": {"type": "string", "title": "name", "default": "anonymous"}
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := c.Compile("schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	annotations, err := sch.ValidateWithAnnotations(decodeString(t, `{"name": "x", "kind": 1, "tags": ["a"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		vloc    string
+		keyword string
+		want    []interface{}
+	}{
+		{"", "title", []interface{}{"root"}},
+		{"/name", "title", []interface{}{"name"}},
+		{"/name", "default", []interface{}{"anonymous"}},
+		{"/kind", "description", []interface{}{"kind as integer"}}, // failed anyOf branch is dropped
+		{"/tags/0", "format", []interface{}{"hostname"}},
+		{"/tags/0", "default", []interface{}{"localhost"}},
+		{"/name", "nonexisting", nil},
+	}
+	for _, test := range tests {
+		got := annotations.Get(test.vloc, test.keyword)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%q %s: got %v, want %v", test.vloc, test.keyword, got, test.want)
+		}
+	}
+	for vloc, list := range annotations {
+		for _, a := range list {
+			if a.Keyword == "title" && a.Value == "property name" {
+				t.Errorf("%q: propertyNames annotation must not be retained", vloc)
+			}
+		}
+	}
+	if got, want := annotations["/name"][0].KeywordLocation, "/properties/name/$ref/title"; got != want {
+		t.Errorf("keywordLocation: got %q, want %q", got, want)
+	}
+	if got, want := annotations["/name"][0].AbsoluteKeywordLocation, sch.Location+"/$defs/name/title"; got != want {
+		t.Errorf("absoluteKeywordLocation: got %q, want %q", got, want)
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		annotations, err := sch.ValidateWithAnnotations(decodeString(t, `{"name": 1}`))
+		if err == nil {
+			t.Fatal("error expected")
+		}
+		if annotations != nil {
+			t.Fatalf("annotations must not be returned: %v", annotations)
+		}
+	})
+}
+
+func TestValidateAndApplyDefaults(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	c.ExtractAnnotations = true
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"properties": {
+			"port": {"type": "integer", "default": 8080},
+			"host": {"$ref": "#/$defs/host"},
+			"tls": {
+				"properties": {
+					"enabled": {"default": false},
+					"ciphers": {"default": ["a", "b"]}
+				}
+			},
+			"comment": {"default": "none"},
+			"items": {"items": {"properties": {"x": {"default": 1}}}}
+		},
+		"anyOf": [
+			{"required": ["mode"], "properties": {"mode": {"const": "a"}, "level": {"default": "from-a"}}},
+			{"properties": {"mode": {"const": "b"}, "level": {"default": "from-b"}}}
+		],
+		"$defs": {
+			"host": {"type": "string", "default": "localhost"}
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := c.Compile("schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := sch.ValidateAndApplyDefaults(decodeString(t, `{"tls": {}, "comment": null, "mode": "b", "items": [{}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(doc)
+	want := `{"comment":null,"host":"localhost","items":[{"x":1}],"level":"from-b","mode":"b","port":8080,"tls":{"ciphers":["a","b"],"enabled":false}}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	t.Run("defaultNotShared", func(t *testing.T) {
+		doc, err := sch.ValidateAndApplyDefaults(decodeString(t, `{"tls": {}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tls := doc.(map[string]interface{})["tls"].(map[string]interface{})
+		tls["ciphers"].([]interface{})[0] = "changed"
+		doc, err = sch.ValidateAndApplyDefaults(decodeString(t, `{"tls": {}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tls = doc.(map[string]interface{})["tls"].(map[string]interface{})
+		if got := tls["ciphers"].([]interface{})[0]; got != "a" {
+			t.Errorf("got %v, want a", got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		doc, err := sch.ValidateAndApplyDefaults(decodeString(t, `{"port": "x"}`))
+		if err == nil {
+			t.Fatal("error expected")
+		}
+		if _, ok := doc.(map[string]interface{})["host"]; ok {
+			t.Error("defaults must not be applied on validation failure")
+		}
+	})
+}
+
+func TestValidateContext(t *testing.T) {
+	sch, err := jsonschema.CompileString("schema.json", `{"items": {"type": "integer"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := decodeString(t, `[1, 2, 3]`)
+	if err := sch.ValidateContext(context.Background(), doc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sch.ValidateContext(ctx, doc); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCompileContext(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("schema.json", strings.NewReader(`{"$ref": "http://example.com/other.json"}`)); err != nil {
+		t.Fatal(err)
+	}
+	var loaded []string
+	c.LoadURLContext = func(ctx context.Context, s string) (io.ReadCloser, error) {
+		loaded = append(loaded, s)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(strings.NewReader(`{}`)), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.CompileContext(ctx, "schema.json"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if len(loaded) != 0 {
+		t.Fatalf("loader must not be called after ctx is done: %v", loaded)
+	}
+
+	if _, err := c.CompileContext(context.Background(), "schema.json"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://example.com/other.json"}; fmt.Sprint(loaded) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", loaded, want)
+	}
+
+	// nested CompileContext restores ctx of the outer one
+	type key struct{}
+	c = jsonschema.NewCompiler()
+	if err := c.AddResource("inner.json", strings.NewReader(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	c.LoadURLContext = func(ctx context.Context, s string) (io.ReadCloser, error) {
+		if ctx.Value(key{}) == nil {
+			t.Errorf("%s: loaded without outer ctx", s)
+		}
+		if strings.HasSuffix(s, "/a.json") {
+			if _, err := c.CompileContext(context.Background(), "inner.json"); err != nil {
+				t.Error(err)
+			}
+			return io.NopCloser(strings.NewReader(`{"$ref": "b.json"}`)), nil
+		}
+		return io.NopCloser(strings.NewReader(`{}`)), nil
+	}
+	ctx = context.WithValue(context.Background(), key{}, true)
+	if _, err := c.CompileContext(ctx, "http://example.com/a.json"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstanceValue(t *testing.T) {
+	sch, err := jsonschema.CompileString("schema.json", `{
+		"properties": {
+			"a": {"items": {"type": "integer"}}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := decodeString(t, `{"a": [1, "abc"]}`)
+	err = sch.Validate(doc)
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	ve := err.(*jsonschema.ValidationError)
+	if got, ok := ve.InstanceValue.(map[string]interface{}); !ok || len(got) != 1 {
+		t.Errorf("root: got %v, want instance", ve.InstanceValue)
+	}
+	leaf := leafError(ve)
+	if leaf.InstanceLocation != "/a/1" || leaf.InstanceValue != "abc" {
+		t.Errorf("leaf: got %q=%v, want %q=%v", leaf.InstanceLocation, leaf.InstanceValue, "/a/1", "abc")
+	}
+}
+
+func TestStrict(t *testing.T) {
+	tests := []struct {
+		draft  *jsonschema.Draft
+		schema string
+		err    string
+	}{
+		{jsonschema.Draft7, `{"properties": {"a": {"minimun": 1}}}`, `unknown keyword "minimun" in file:///`},
+		{jsonschema.Draft7, `{"minLength": 1, "maxLenght": 2, "patern": "a"}`, `unknown keyword "maxLenght", "patern" in`},
+		{jsonschema.Draft7, `{"$defs": {}}`, `unknown keyword "$defs"`},
+		{jsonschema.Draft4, `{"$ref": "#/definitions/a", "definitions": {"a": {}}}`, ""},
+		{jsonschema.Draft7, `{"x-internal": true, "$comment": "c", "if": {}, "then": {}}`, ""},
+		{jsonschema.Draft2019, `{"$defs": {"a": {"dependentRequired": {}}}, "$recursiveAnchor": true, "unevaluatedProperties": false}`, ""},
+		{jsonschema.Draft2020, `{"prefixItems": [{}], "$dynamicAnchor": "a", "deprecated": true, "contentSchema": {}}`, ""},
+		{jsonschema.Draft2020, `{"powerOf": 10}`, ""},
+		{jsonschema.Draft2020, `{"powerof": 10}`, `unknown keyword "powerof"`},
+	}
+	for i, test := range tests {
+		c := jsonschema.NewCompiler()
+		c.Draft = test.draft
+		c.Strict = true
+		c.RegisterExtension("powerOf", powerOfMeta, powerOfCompiler{})
+		if err := c.AddResource("schema.json", strings.NewReader(test.schema)); err != nil {
+			t.Fatal(err)
+		}
+		_, err := c.Compile("schema.json")
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		case test.err != "" && err == nil:
+			t.Errorf("#%d: error expected", i)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("#%d: got %q, want it to contain %q", i, err, test.err)
+		}
+	}
+
+	t.Run("location", func(t *testing.T) {
+		c := jsonschema.NewCompiler()
+		c.Strict = true
+		if err := c.AddResource("schema.json", strings.NewReader(`{"properties": {"a": {"minimun": 1}}}`)); err != nil {
+			t.Fatal(err)
+		}
+		_, err := c.Compile("schema.json")
+		if err == nil || !strings.HasSuffix(err.Error(), "schema.json#/properties/a") {
+			t.Fatalf("got %v, want location of subschema", err)
+		}
+	})
+}
+
+func TestErrorMessage(t *testing.T) {
+	sch, err := jsonschema.CompileString("schema.json", `{
+		"properties": {
+			"name": {
+				"type": "string",
+				"minLength": 3,
+				"errorMessage": {"minLength": "name is too short"}
+			},
+			"zip": {
+				"type": "string",
+				"pattern": "^[0-9]{5}$",
+				"errorMessage": "zip must be 5 digits"
+			},
+			"age": {"$ref": "#/$defs/age"}
+		},
+		"required": ["name"],
+		"errorMessage": {"required": "name is mandatory", "properties": "invalid properties"},
+		"$defs": {
+			"age": {"type": "integer", "errorMessage": {"type": "age must be integer"}}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		instance string
+		want     []string
+	}{
+		{`{"name": "ab"}`, []string{"invalid properties"}},
+		{`{}`, []string{"name is mandatory"}},
+		{`{"name": "abc", "zip": 1}`, []string{"invalid properties"}},
+	}
+	for _, test := range tests {
+		err := sch.Validate(decodeString(t, test.instance))
+		if err == nil {
+			t.Fatalf("%s: error expected", test.instance)
+		}
+		var got []string
+		for _, leaf := range err.(*jsonschema.ValidationError).LeafErrors() {
+			got = append(got, leaf.Message.String())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got %q, want %q", test.instance, got, test.want)
+		}
+	}
+
+	t.Run("nested", func(t *testing.T) {
+		sch, err := jsonschema.CompileString("schema.json", `{
+			"properties": {
+				"name": {"type": "string", "minLength": 3, "errorMessage": {"minLength": "name is too short"}},
+				"zip": {"type": "string", "pattern": "^[0-9]{5}$", "errorMessage": "zip must be 5 digits"},
+				"age": {"$ref": "#/$defs/age"}
+			},
+			"$defs": {
+				"age": {"type": "integer", "errorMessage": {"type": "age must be integer"}}
+			}
+		}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			instance string
+			want     string
+		}{
+			{`{"name": "ab"}`, "name is too short"},
+			{`{"name": 1}`, "expected string, but got number"},
+			{`{"zip": 1}`, "zip must be 5 digits"},
+			{`{"zip": "1"}`, "zip must be 5 digits"},
+			{`{"age": "x"}`, "age must be integer"},
+		}
+		for _, test := range tests {
+			err := sch.Validate(decodeString(t, test.instance))
+			if err == nil {
+				t.Fatalf("%s: error expected", test.instance)
+			}
+			leaves := err.(*jsonschema.ValidationError).LeafErrors()
+			if len(leaves) != 1 || leaves[0].Message.String() != test.want {
+				t.Errorf("%s: got %v, want %q", test.instance, leaves, test.want)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{`{"errorMessage": 1}`, `{"errorMessage": {"type": 1}}`} {
+			if _, err := jsonschema.CompileString("schema.json", s); err == nil {
+				t.Errorf("%s: error expected", s)
+			}
+		}
+	})
+}
+
+func TestTranslator(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	c.Translator = func(key string, args map[string]interface{}) string {
+		switch key {
+		case "minLength":
+			return fmt.Sprintf("Länge muss >= %v sein, ist aber %v", args["want"], args["got"])
+		case "required":
+			return fmt.Sprintf("fehlende Eigenschaften: %s", strings.Join(args["want"].([]string), ", "))
+		}
+		return ""
+	}
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"properties": {
+			"name": {"type": "string", "minLength": 3},
+			"age": {"type": "integer"}
+		},
+		"required": ["id"]
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := c.Compile("schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sch.Validate(decodeString(t, `{"name": "ab", "age": "x"}`))
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	got := make(map[string]string)
+	for _, e := range err.(*jsonschema.ValidationError).BasicOutput().Errors {
+		got[e.KeywordLocation] = e.Error
+	}
+	want := map[string]string{
+		"/properties/name/minLength": "Länge muss >= 3 sein, ist aber 2",
+		"/required":                  "fehlende Eigenschaften: id",
+		"/properties/age/type":       "expected integer, but got string", // not translated
+	}
+	for kloc, msg := range want {
+		if got[kloc] != msg {
+			t.Errorf("%s: got %q, want %q", kloc, got[kloc], msg)
+		}
+	}
+
+	for _, leaf := range err.(*jsonschema.ValidationError).LeafErrors() {
+		if leaf.KeywordLocation != "/properties/name/minLength" {
+			continue
+		}
+		if got, want := leaf.MessageKey(), "minLength"; got != want {
+			t.Errorf("key: got %q, want %q", got, want)
+		}
+		if got, want := fmt.Sprint(leaf.MessageArgs()), "map[got:2 want:3]"; got != want {
+			t.Errorf("args: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestValidateBytes(t *testing.T) {
+	sch, err := jsonschema.CompileString("schema.json", `{
+		"properties": {
+			"count": {"type": "integer"},
+			"big": {"const": 12345678901234567890}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`{"count": 1}`, true},
+		{`{"count": 1.0}`, true},
+		{`{"count": 1.5}`, false},
+		{`{"big": 12345678901234567890}`, true},
+		{`{"big": 12345678901234567891}`, false},
+	}
+	for i, test := range tests {
+		err := sch.ValidateBytes([]byte(test.doc))
+		if test.valid != (err == nil) {
+			t.Errorf("#%d: %s: valid %t, got %v", i, test.doc, test.valid, err)
+		}
+		if verr := sch.Validate(decodeString(t, test.doc)); (verr == nil) != (err == nil) {
+			t.Errorf("#%d: %s: Validate and ValidateBytes differ", i, test.doc)
+		}
+	}
+	for _, doc := range []string{``, `{"count": 1`, `{} {}`} {
+		err := sch.ValidateBytes([]byte(doc))
+		if _, ok := err.(*jsonschema.ValidationError); ok || err == nil {
+			t.Errorf("%q: decode error expected, got %v", doc, err)
+		}
+	}
+}
+
+func TestMustCompileString(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		for _, schema := range []string{`{`, `{"type": 1}`} {
+			func() {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("%s: panic expected", schema)
+					}
+				}()
+				jsonschema.MustCompileString("schema.json", schema)
+			}()
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		sch := jsonschema.MustCompileString("http://example.com/schema.json", `{
+			"$defs": {"positive": {"exclusiveMinimum": 0}},
+			"properties": {"count": {"$ref": "#/$defs/positive"}}
+		}`)
+		err := sch.Validate(decodeString(t, `{"count": 0}`))
+		ve, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			t.Fatalf("got %v, want ValidationError", err)
+		}
+		leaf := ve.LeafErrors()[0]
+		if got, want := leaf.AbsoluteKeywordLocation, "http://example.com/schema.json#/$defs/positive/exclusiveMinimum"; got != want {
+			t.Errorf("AbsoluteKeywordLocation: got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestValidateWithResult(t *testing.T) {
+	sch, err := jsonschema.CompileString("schema.json", `{
+		"properties": {
+			"pets": {
+				"items": {
+					"oneOf": [
+						{"$ref": "#/$defs/cat"},
+						{"$ref": "#/$defs/dog"}
+					]
+				}
+			},
+			"id": {
+				"anyOf": [
+					{"type": "string"},
+					{"type": "integer"},
+					{"type": "number"}
+				]
+			}
+		},
+		"$defs": {
+			"cat": {"properties": {"kind": {"const": "cat"}}, "required": ["kind"]},
+			"dog": {"properties": {"kind": {"const": "dog"}}, "required": ["kind"]}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := sch.ValidateWithResult(decodeString(t, `{"pets": [{"kind": "dog"}, {"kind": "cat"}], "id": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		kloc  string
+		vloc  string
+		index int
+		ok    bool
+	}{
+		{"/properties/pets/items/oneOf", "/pets/0", 1, true},
+		{"/properties/pets/items/oneOf", "/pets/1", 0, true},
+		{"/properties/id/anyOf", "/id", 1, true}, // first matching branch
+		{"/properties/id/anyOf", "/pets", -1, false},
+	}
+	for _, test := range tests {
+		index, ok := result.Branch(test.kloc, test.vloc)
+		if index != test.index || ok != test.ok {
+			t.Errorf("%s %q: got (%d, %t), want (%d, %t)", test.kloc, test.vloc, index, ok, test.index, test.ok)
+		}
+	}
+	for _, b := range result.Branches {
+		if got, want := b.AbsoluteKeywordLocation, sch.Location+b.KeywordLocation; got != want {
+			t.Errorf("absoluteKeywordLocation: got %q, want %q", got, want)
+		}
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, doc := range []string{`{"pets": [{"kind": "cow"}]}`, `{"id": null}`} {
+			result, err := sch.ValidateWithResult(decodeString(t, doc))
+			if err == nil {
+				t.Fatalf("%s: error expected", doc)
+			}
+			if result != nil {
+				t.Fatalf("%s: result must not be returned: %v", doc, result)
+			}
+		}
+	})
+}
+
+func TestMaxDepth(t *testing.T) {
+	t.Run("schema", func(t *testing.T) {
+		c := jsonschema.NewCompiler()
+		c.MaxSchemaDepth = 4
+		if err := c.AddResource("schema.json", strings.NewReader(`{"items": {"items": {"items": {"type": "array"}}}}`)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Compile("schema.json"); err == nil {
+			t.Fatal("error expected")
+		}
+		c.MaxSchemaDepth = 0
+		if _, err := c.Compile("schema.json"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ref", func(t *testing.T) {
+		schema := `{
+			"$ref": "#/$defs/a",
+			"$defs": {
+				"a": {"$ref": "#/$defs/b"},
+				"b": {"$ref": "#/$defs/c"},
+				"c": {"type": "string"}
+			}
+		}`
+		for _, test := range []struct {
+			maxRefDepth int
+			valid       bool
+		}{{0, true}, {3, true}, {2, false}} {
+			c := jsonschema.NewCompiler()
+			c.MaxRefDepth = test.maxRefDepth
+			if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+				t.Fatal(err)
+			}
+			_, err := c.Compile("schema.json")
+			if test.valid != (err == nil) {
+				t.Errorf("MaxRefDepth=%d: valid %t, got %v", test.maxRefDepth, test.valid, err)
+			}
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		nested := func(n int) interface{} {
+			var v interface{} = []interface{}{}
+			for i := 0; i < n; i++ {
+				v = []interface{}{v}
+			}
+			return v
+		}
+		c := jsonschema.NewCompiler()
+		c.MaxValidationDepth = 10
+		if err := c.AddResource("schema.json", strings.NewReader(`{"items": {"$ref": "#"}}`)); err != nil {
+			t.Fatal(err)
+		}
+		sch, err := c.Compile("schema.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sch.Validate(nested(3)); err != nil {
+			t.Fatal(err)
+		}
+		err = sch.Validate(nested(10))
+		if _, ok := err.(jsonschema.DepthError); !ok {
+			t.Fatalf("got %v, want DepthError", err)
+		}
+
+		// no limit by default
+		sch = jsonschema.MustCompileString("schema.json", `{"items": {"$ref": "#"}}`)
+		if err := sch.Validate(nested(20000)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestMaxConcurrentLoads(t *testing.T) {
+	docs := map[string]string{
+		"map:///schema.json": `{"properties": {
+			"a": {"$ref": "a.json"},
+			"b": {"$ref": "b.json#/$defs/b"},
+			"c": {"$ref": "c.json"},
+			"d": {"$id": "http://example.com/d.json", "type": "string"},
+			"e": {"$ref": "http://example.com/d.json"}
+		}}`,
+		"map:///a.json":      `{"$ref": "common.json"}`,
+		"map:///b.json":      `{"$defs": {"b": {"$ref": "common.json"}}}`,
+		"map:///c.json":      `{"$ref": "common.json", "default": {"$ref": "data.json"}}`,
+		"map:///common.json": `{"type": "integer"}`,
+	}
+	var mu sync.Mutex
+	var active, maxActive int
+	loads := make(map[string]int)
+	c := jsonschema.NewCompiler()
+	c.MaxConcurrentLoads = 3
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		mu.Lock()
+		loads[s]++
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		if doc, ok := docs[s]; ok {
+			return io.NopCloser(strings.NewReader(doc)), nil
+		}
+		return nil, fmt.Errorf("%s not found", s)
+	}
+	sch, err := c.Compile("map:///schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sch.Validate(decodeString(t, `{"a": 1, "b": 2, "c": 3}`)); err != nil {
+		t.Fatal(err)
+	}
+	for url := range docs {
+		if loads[url] != 1 {
+			t.Errorf("%s: loaded %d times", url, loads[url])
+		}
+	}
+	if len(loads) != len(docs) {
+		t.Errorf("loaded %v, want only %d docs", loads, len(docs))
+	}
+	if maxActive < 2 || maxActive > c.MaxConcurrentLoads {
+		t.Errorf("concurrent loads: got %d, want between 2 and %d", maxActive, c.MaxConcurrentLoads)
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		schema := `{"allOf": [{"$ref": "missing1.json"}, {"$ref": "missing2.json"}]}`
+		var want string
+		for _, n := range []int{0, 4} {
+			c := jsonschema.NewCompiler()
+			c.MaxConcurrentLoads = n
+			c.LoadURL = func(s string) (io.ReadCloser, error) {
+				return nil, fmt.Errorf("%s not found", s)
+			}
+			if err := c.AddResource("map:///schema.json", strings.NewReader(schema)); err != nil {
+				t.Fatal(err)
+			}
+			_, err := c.Compile("map:///schema.json")
+			if err == nil {
+				t.Fatal("error expected")
+			}
+			if n == 0 {
+				want = err.Error()
+			} else if err.Error() != want {
+				t.Errorf("MaxConcurrentLoads=%d: got %q, want %q", n, err, want)
+			}
+		}
+	})
+}
+
+func TestCompileRegexCache(t *testing.T) {
+	compiled := make(map[string]int)
+	c := jsonschema.NewCompiler()
+	c.CompileRegex = func(s string) (jsonschema.Regexp, error) {
+		compiled[s]++
+		return regexp.Compile(s)
+	}
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"properties": {
+			"a": {"pattern": "^[a-z]+$"},
+			"b": {"pattern": "^[a-z]+$"},
+			"c": {"patternProperties": {"^[a-z]+$": {"pattern": "^[0-9]+$"}}}
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch := c.MustCompile("schema.json")
+	if err := c.AddResource("other.json", strings.NewReader(`{"items": {"pattern": "^[0-9]+$"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	c.MustCompile("other.json")
+	for pattern, n := range compiled {
+		if n != 1 {
+			t.Errorf("%s: compiled %d times", pattern, n)
+		}
+	}
+	if len(compiled) != 2 {
+		t.Errorf("got %v, want 2 patterns", compiled)
+	}
+	if sch.Properties["a"].Pattern != sch.Properties["b"].Pattern {
+		t.Error("identical patterns must share Regexp")
+	}
+
+	// clone does not reuse regexes compiled by another CompileRegex
+	clone := c.Clone()
+	cloned := make(map[string]int)
+	clone.CompileRegex = func(s string) (jsonschema.Regexp, error) {
+		cloned[s]++
+		return regexp.Compile(s)
+	}
+	csch := clone.MustCompile("schema.json")
+	if len(cloned) != 2 {
+		t.Errorf("clone: got %v, want 2 patterns", cloned)
+	}
+	if csch.Properties["a"].Pattern == sch.Properties["a"].Pattern {
+		t.Error("clone: must not share Regexp with base")
+	}
+}
+
+func TestPropertyNamesError(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {
+			"obj": {"propertyNames": {"pattern": "^[a-z]+$", "maxLength": 3}}
+		}
+	}`)
+	err := sch.Validate(decodeString(t, `{"obj": {"abc": 1, "Abc": 2, "a/bcd": 3}}`))
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		t.Fatalf("got %v, want ValidationError", err)
+	}
+	if len(ve.Causes) != 2 {
+		t.Fatalf("got %d causes, want 2: %#v", len(ve.Causes), ve)
+	}
+	want := map[string]string{
+		"/obj/Abc":    `invalid property name 'Abc'`,
+		"/obj/a~1bcd": `invalid property name 'a/bcd'`,
+	}
+	for _, cause := range ve.Causes {
+		if got := cause.Message.String(); got != want[cause.InstanceLocation] {
+			t.Errorf("%q: got %q, want %q", cause.InstanceLocation, got, want[cause.InstanceLocation])
+		}
+		if got, want := cause.KeywordLocation, "/properties/obj/propertyNames"; got != want {
+			t.Errorf("%q: keywordLocation: got %q, want %q", cause.InstanceLocation, got, want)
+		}
+		if cause.MessageKey() != "propertyNames" {
+			t.Errorf("%q: got message key %q", cause.InstanceLocation, cause.MessageKey())
+		}
+		if len(cause.Causes) == 0 {
+			t.Errorf("%q: causes expected", cause.InstanceLocation)
+		}
+	}
+}
+
+func TestCrossDraftRef(t *testing.T) {
+	schemas := map[string]string{
+		// no $schema, so Compiler.Draft is used. siblings of $ref are ignored.
+		"main.json": `{"$ref": "draft2019.json", "maxLength": 1}`,
+		// siblings of $ref are applied.
+		"draft2019.json": `{
+			"$schema": "https://json-schema.org/draft/2019-09/schema",
+			"$ref": "draft4.json",
+			"dependentRequired": {"a": ["b"]}
+		}`,
+		// boolean exclusiveMaximum.
+		"draft4.json": `{
+			"$schema": "http://json-schema.org/draft-04/schema#",
+			"properties": {"n": {"maximum": 10, "exclusiveMaximum": true}}
+		}`,
+	}
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft7
+	for url, schema := range schemas {
+		if err := c.AddResource(url, strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sch, err := c.Compile("main.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sch.Draft != jsonschema.Draft7 || sch.Ref.Draft != jsonschema.Draft2019 || sch.Ref.Ref.Draft != jsonschema.Draft4 {
+		t.Errorf("drafts: got %s, %s, %s", sch.Draft, sch.Ref.Draft, sch.Ref.Ref.Draft)
+	}
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`"long string"`, true},
+		{`{"a": 1, "b": 2, "n": 9}`, true},
+		{`{"a": 1}`, false},
+		{`{"n": 10}`, false},
+	}
+	for _, test := range tests {
+		err := sch.Validate(decodeString(t, test.doc))
+		if test.valid != (err == nil) {
+			t.Errorf("%s: valid %t, got %v", test.doc, test.valid, err)
+		}
+	}
+
+	// each document is validated against metaschema of its own draft
+	c = jsonschema.NewCompiler()
+	if err := c.AddResource("main.json", strings.NewReader(`{"$ref": "draft4.json"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddResource("draft4.json", strings.NewReader(`{
+		"$schema": "http://json-schema.org/draft-04/schema#",
+		"exclusiveMinimum": 1
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Compile("main.json"); err == nil {
+		t.Error("numeric exclusiveMinimum must be invalid in draft4")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"$defs": {
+			"node": {
+				"properties": {
+					"value": {"enum": [1, 2]},
+					"children": {"items": {"$ref": "#/$defs/node"}}
+				},
+				"required": ["value"]
+			}
+		},
+		"properties": {
+			"root": {"$ref": "#/$defs/node"},
+			"a/b": {"minimum": 0},
+			"skip": {"properties": {"x": {}}}
+		},
+		"anyOf": [{"type": "object"}, {"type": "null"}]
+	}`)
+
+	var paths []string
+	sch.Walk(func(path string, s *jsonschema.Schema) bool {
+		paths = append(paths, path)
+		switch path {
+		case "/properties/root/$ref":
+			if len(s.Required) != 1 || s.Required[0] != "value" {
+				t.Errorf("%s: got required %v", path, s.Required)
+			}
+		case "/properties/a~1b":
+			if s.Minimum == nil || s.Minimum.Sign() != 0 {
+				t.Errorf("%s: got minimum %v", path, s.Minimum)
+			}
+		}
+		return path != "/properties/skip"
+	})
+	want := []string{
+		"",
+		"/anyOf/0",
+		"/anyOf/1",
+		"/properties/a~1b",
+		"/properties/root",
+		"/properties/root/$ref",
+		"/properties/root/$ref/properties/children",
+		"/properties/root/$ref/properties/children/items", // its $ref is recursive
+		"/properties/root/$ref/properties/value",
+		"/properties/skip",
+	}
+	if strings.Join(paths, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(paths, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSchemaFields(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {
+			"zero": {"minimum": 0, "maxLength": 0, "const": null},
+			"absent": {},
+			"enum": {"enum": ["a", "b"]}
+		},
+		"required": ["zero"]
+	}`)
+	zero, absent := sch.Properties["zero"], sch.Properties["absent"]
+	if zero.Minimum == nil || zero.Minimum.Sign() != 0 {
+		t.Errorf("minimum: got %v, want 0", zero.Minimum)
+	}
+	if absent.Minimum != nil {
+		t.Errorf("minimum: got %v, want nil", absent.Minimum)
+	}
+	if zero.MaxLength != 0 || absent.MaxLength != -1 {
+		t.Errorf("maxLength: got %d and %d, want 0 and -1", zero.MaxLength, absent.MaxLength)
+	}
+	if len(zero.Constant) != 1 || zero.Constant[0] != nil || absent.Constant != nil {
+		t.Errorf("const: got %v and %v", zero.Constant, absent.Constant)
+	}
+	if got := fmt.Sprint(sch.Properties["enum"].Enum); got != "[a b]" {
+		t.Errorf("enum: got %s", got)
+	}
+	if got := fmt.Sprint(sch.Required); got != "[zero]" {
+		t.Errorf("required: got %s", got)
+	}
+}
+
+func TestReadOnlyWriteOnly(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	c.ExtractAnnotations = true
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"properties": {
+			"id": {"$ref": "#/$defs/id"},
+			"password": {"type": "string", "writeOnly": true},
+			"name": {"type": "string", "deprecated": true}
+		},
+		"$defs": {
+			"id": {"type": "integer", "readOnly": true}
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch := c.MustCompile("schema.json")
+	if !sch.Properties["id"].Ref.ReadOnly || !sch.Properties["password"].WriteOnly || !sch.Properties["name"].Deprecated {
+		t.Fatal("annotations must be extracted")
+	}
+
+	tests := []struct {
+		doc        string
+		validWrite bool
+		validRead  bool
+	}{
+		{`{"name": "x"}`, true, true},
+		{`{"id": 1}`, false, true},
+		{`{"password": "secret"}`, true, false},
+	}
+	for _, test := range tests {
+		doc := decodeString(t, test.doc)
+		if err := sch.Validate(doc); err != nil {
+			t.Errorf("%s: annotations must not be asserted by Validate: %v", test.doc, err)
+		}
+		if err := sch.ValidateWrite(doc); test.validWrite != (err == nil) {
+			t.Errorf("%s: ValidateWrite: valid %t, got %v", test.doc, test.validWrite, err)
+		}
+		if err := sch.ValidateRead(doc); test.validRead != (err == nil) {
+			t.Errorf("%s: ValidateRead: valid %t, got %v", test.doc, test.validRead, err)
+		}
+	}
+
+	err := sch.ValidateWrite(decodeString(t, `{"id": 1}`))
+	leaf := err.(*jsonschema.ValidationError).LeafErrors()[0]
+	if leaf.InstanceLocation != "/id" || leaf.KeywordLocation != "/properties/id/$ref/readOnly" {
+		t.Errorf("got %q %q", leaf.InstanceLocation, leaf.KeywordLocation)
+	}
+}
+
+func TestValidateStream(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{"required": ["id"]}`)
+	stream := strings.Join([]string{
+		`{"id": 1}`,
+		`{"name": "x"}`,
+		``,
+		`{"id": `,
+		`{"id": 2} {"id": 3}`,
+		`{"id": 4}`,
+	}, "\n")
+	var got []string
+	for err := range sch.ValidateStream(strings.NewReader(stream)) {
+		re, ok := err.(*jsonschema.RecordError)
+		if !ok {
+			t.Fatalf("got %v, want RecordError", err)
+		}
+		_, invalid := re.Err.(*jsonschema.ValidationError)
+		got = append(got, fmt.Sprintf("%d:%t", re.Line, invalid))
+	}
+	if want := "2:true 4:false 5:false"; strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+
+	t.Run("read error", func(t *testing.T) {
+		errRead := errors.New("read failed")
+		r := io.MultiReader(strings.NewReader("{\"name\": 1}\n{\"id\""), iotest.ErrReader(errRead))
+		var errs []error
+		for err := range sch.ValidateStream(r) {
+			errs = append(errs, err)
+		}
+		if len(errs) != 2 || errs[1] != errRead {
+			t.Errorf("got %v", errs)
+		}
+	})
+}
+
+func TestFormatMode(t *testing.T) {
+	schema := `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"properties": {
+			"at": {"format": "date-time"},
+			"tags": {"anyOf": [{"type": "integer"}, {"format": "hostname"}]}
+		}
+	}`
+	doc := `{"at": "yesterday", "tags": "-bad-"}`
+	tests := []struct {
+		mode     jsonschema.FormatMode
+		assert   bool
+		valid    bool
+		warnings int
+	}{
+		{jsonschema.FormatDefault, false, true, 0},
+		{jsonschema.FormatDefault, true, false, 0},
+		{jsonschema.FormatIgnore, true, true, 0},
+		{jsonschema.FormatWarn, false, true, 2},
+		{jsonschema.FormatAssert, false, false, 0},
+		{jsonschema.FormatDisable, true, true, 0},
+	}
+	for i, test := range tests {
+		c := jsonschema.NewCompiler()
+		c.FormatMode = test.mode
+		c.AssertFormat = test.assert
+		if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		sch := c.MustCompile("schema.json")
+		if got, disabled := sch.Properties["at"].Format, test.mode == jsonschema.FormatDisable; disabled != (got == "") {
+			t.Errorf("#%d: got Format %q", i, got)
+		}
+		result, err := sch.ValidateWithResult(decodeString(t, doc))
+		if test.valid != (err == nil) {
+			t.Errorf("#%d: valid %t, got %v", i, test.valid, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(result.Warnings) != test.warnings {
+			t.Errorf("#%d: got %d warnings, want %d", i, len(result.Warnings), test.warnings)
+		}
+		for _, w := range result.Warnings {
+			if w.MessageKey() != "format" {
+				t.Errorf("#%d: got warning %v", i, w)
+			}
+		}
+	}
+}
+
+func TestRetrievalURI(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("%s not found", s)
+	}
+	resources := map[string]string{
+		"https://a/s.json": `{
+			"$id": "https://canonical/s.json",
+			"properties": {
+				"other": {"$ref": "other.json"},
+				"self": {"$ref": "#/$defs/positive"}
+			},
+			"$defs": {"positive": {"exclusiveMinimum": 0}}
+		}`,
+		"https://canonical/other.json": `{"type": "string"}`,
+		"https://b/main.json":          `{"$ref": "https://canonical/s.json"}`,
+	}
+	for url, schema := range resources {
+		if err := c.AddResource(url, strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sch, err := c.Compile("https://a/s.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sch.Location != "https://canonical/s.json#" {
+		t.Errorf("location: got %q", sch.Location)
+	}
+	if err := sch.Validate(decodeString(t, `{"other": "x", "self": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sch.Validate(decodeString(t, `{"other": 1}`)); err == nil {
+		t.Fatal("error expected")
+	}
+
+	// declared $id can be referred, once the document is loaded by its retrieval uri
+	main, err := c.Compile("https://b/main.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main.Ref != sch {
+		t.Error("$ref to declared $id must resolve to same schema")
+	}
+
+	// declared $id can be referred, before the document is loaded by its retrieval uri
+	c = jsonschema.NewCompiler()
+	for url, schema := range resources {
+		if err := c.AddResource(url, strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Compile("https://b/main.json"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetaSchemaError(t *testing.T) {
+	_, err := jsonschema.CompileString("schema.json", `{
+		"properties": {
+			"name": {"type": "string", "required": "name"}
+		}
+	}`)
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("got %v, want SchemaError wrapping ValidationError", err)
+	}
+	leaf := ve.LeafErrors()[0]
+	if got, want := leaf.InstanceLocation, "/properties/name/required"; got != want {
+		t.Errorf("instanceLocation: got %q, want %q", got, want)
+	}
+	if got, want := leaf.MessageKey(), "type"; got != want {
+		t.Errorf("message key: got %q, want %q", got, want)
+	}
+}
+
+func TestSubschema(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {
+			"address": {"$ref": "#/$defs/address"},
+			"tags": {"items": {"type": "string"}},
+			"a/b": {"anyOf": [{"type": "null"}, {"type": "integer"}]}
+		},
+		"patternProperties": {"^x-": {"type": "string"}},
+		"$defs": {
+			"address": {"properties": {"street": {"type": "string"}}},
+			"unused": {"properties": {"id": {"$ref": "#name"}}},
+			"a/b": {"type": "integer"},
+			"name": {"$anchor": "name", "type": "string"},
+			"nested": {"$id": "nested.json", "$defs": {"x": {"$anchor": "x"}}}
+		}
+	}`)
+	tests := []struct {
+		ptr  string
+		want string // location of subschema, empty if error expected
+	}{
+		{"", "#"},
+		{"#", "#"},
+		{"/properties/address", "#/properties/address"},
+		{"/properties/address/$ref", "#/$defs/address"},
+		{"/properties/address/properties/street", "#/$defs/address/properties/street"},
+		{"#/properties/tags/items", "#/properties/tags/items"},
+		{"/properties/a~1b/anyOf/1", "#/properties/a~1b/anyOf/1"},
+		{"#/properties/a~1b/anyOf/1", "#/properties/a~1b/anyOf/1"},
+		{"/patternProperties/^x-", "#/patternProperties/%5Ex-"},
+		{"/properties/missing", ""},
+		{"/properties/a~1b/anyOf/2", ""},
+		{"/properties/a~1b/anyOf/01", ""},
+		{"/properties/tags/items/0", ""},
+		{"/$defs/address", "#/$defs/address"},
+		{"/$defs/unused/properties/id", "#/$defs/unused/properties/id"},
+		{"/$defs/unused/properties/id/$ref", "#/$defs/name"},
+		{"#/$defs/a~1b", "#/$defs/a~1b"},
+		{"/$defs/missing", ""},
+		{"/$defs", ""},
+		{"#name", "#/$defs/name"},
+		{"#missing", ""},
+		{"#x", ""}, // anchor of other resource
+		{"properties", ""},
+	}
+	for _, test := range tests {
+		sub, err := sch.Subschema(test.ptr)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q: error expected, got %s", test.ptr, sub.Location)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.ptr, err)
+			continue
+		}
+		if want := strings.TrimSuffix(sch.Location, "#") + test.want; sub.Location != want {
+			t.Errorf("%q: got %s, want %s", test.ptr, sub.Location, want)
+		}
+	}
+
+	// anchors are looked up in the resource of the subschema
+	nested, err := sch.Subschema("/$defs/nested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x, err := nested.Subschema("#x"); err != nil {
+		t.Error(err)
+	} else if want := nested.Location + "/$defs/x"; x.Location != want {
+		t.Errorf("#x: got %s, want %s", x.Location, want)
+	}
+
+	// draft-07 definitions and plain-name $id
+	sch = jsonschema.MustCompileString("draft7.json", `{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"definitions": {
+			"user": {"$id": "#user", "required": ["name"]}
+		}
+	}`)
+	for _, ptr := range []string{"/definitions/user", "#/definitions/user", "#user"} {
+		sub, err := sch.Subschema(ptr)
+		if err != nil {
+			t.Errorf("%q: %v", ptr, err)
+			continue
+		}
+		if err := sub.Validate(decodeString(t, `{}`)); err == nil {
+			t.Errorf("%q: want error from required", ptr)
+		}
+	}
+}
+
+func TestBundle(t *testing.T) {
+	schemas := map[string]string{
+		"map:///main.json": `{
+			"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"properties": {
+				"home": {"$ref": "address/address.json"},
+				"person": {"$ref": "person.json"},
+				"id": {"$ref": "common.json#/$defs/id"},
+				"tree": {"$ref": "tree.json"}
+			},
+			"$defs": {"common.json": {"type": "object"}}
+		}`,
+		"map:///address/address.json": `{
+			"properties": {
+				"zip": {"$ref": "common.json#/$defs/id"},
+				"street": {"type": "string"}
+			},
+			"required": ["street"]
+		}`,
+		"map:///address/common.json": `{"$defs": {"id": {"type": "string", "pattern": "^[0-9]+$"}}}`,
+		"map:///common.json":         `{"$defs": {"id": {"type": "integer"}}}`,
+		"map:///person.json": `{
+			"$id": "http://example.com/person.json",
+			"properties": {"name": {"$ref": "#/$defs/name"}, "address": {"$ref": "map:///address/address.json"}},
+			"$defs": {"name": {"type": "string", "minLength": 1}}
+		}`,
+		"map:///tree.json": `{"items": {"$ref": "#"}, "maxItems": 2}`,
+	}
+	c := jsonschema.NewCompiler()
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		if schema, ok := schemas[s]; ok {
+			return io.NopCloser(strings.NewReader(schema)), nil
+		}
+		return nil, fmt.Errorf("%s not found", s)
+	}
+	bundle, err := c.Bundle("map:///main.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := c.Compile("map:///main.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bundle must be self-contained
+	c = jsonschema.NewCompiler()
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("%s must not be loaded", s)
+	}
+	if err := c.AddResource("bundle.json", bytes.NewReader(bundle)); err != nil {
+		t.Fatal(err)
+	}
+	bundled, err := c.Compile("bundle.json")
+	if err != nil {
+		t.Fatalf("%v\n%s", err, bundle)
+	}
+
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`{}`, true},
+		{`{"id": 1}`, true},
+		{`{"id": "1"}`, false},
+		{`{"home": {"street": "x", "zip": "123"}}`, true},
+		{`{"home": {"street": "x", "zip": 123}}`, false},
+		{`{"home": {"zip": "123"}}`, false},
+		{`{"person": {"name": "x", "address": {"street": "y"}}}`, true},
+		{`{"person": {"name": ""}}`, false},
+		{`{"person": {"address": {}}}`, false},
+		{`{"tree": [[], [[]]]}`, true},
+		{`{"tree": [[], [[], [], []]]}`, false},
+	}
+	for _, test := range tests {
+		v := decodeString(t, test.doc)
+		if err := orig.Validate(v); test.valid != (err == nil) {
+			t.Errorf("%s: original: valid %t, got %v", test.doc, test.valid, err)
+		}
+		if err := bundled.Validate(v); test.valid != (err == nil) {
+			t.Errorf("%s: bundled: valid %t, got %v", test.doc, test.valid, err)
+		}
+	}
+
+	t.Run("draft7", func(t *testing.T) {
+		c := jsonschema.NewCompiler()
+		if err := c.AddResource("schema.json", strings.NewReader(`{"$schema": "http://json-schema.org/draft-07/schema#"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Bundle("schema.json"); err == nil {
+			t.Error("error expected")
+		}
+	})
+}
+
+func TestRefSiblings(t *testing.T) {
+	tests := []struct {
+		draft   string
+		defs    string
+		applied bool
+	}{
+		{"http://json-schema.org/draft-04/schema#", "definitions", false},
+		{"http://json-schema.org/draft-06/schema#", "definitions", false},
+		{"http://json-schema.org/draft-07/schema#", "definitions", false},
+		{"https://json-schema.org/draft/2019-09/schema", "$defs", true},
+		{"https://json-schema.org/draft/2020-12/schema", "$defs", true},
+	}
+	for _, test := range tests {
+		sch, err := jsonschema.CompileString("schema.json", fmt.Sprintf(`{
+			"$schema": %q,
+			"properties": {
+				"name": {"$ref": "#/%s/str", "minLength": 5}
+			},
+			%q: {"str": {"type": "string"}}
+		}`, test.draft, test.defs, test.defs))
+		if err != nil {
+			t.Fatalf("%s: %v", test.draft, err)
+		}
+		if err := sch.Validate(decodeString(t, `{"name": 1}`)); err == nil {
+			t.Errorf("%s: $ref must be applied", test.draft)
+		}
+		err = sch.Validate(decodeString(t, `{"name": "abc"}`))
+		if test.applied != (err != nil) {
+			t.Errorf("%s: siblings of $ref applied %t, got %v", test.draft, test.applied, err)
+		}
+	}
+}
+
+func TestAllowRemoteRefs(t *testing.T) {
+	var loaded []string
+	newCompiler := func(schema string) *jsonschema.Compiler {
+		c := jsonschema.NewCompiler()
+		c.AllowRemoteRefs = false
+		c.LoadURL = func(s string) (io.ReadCloser, error) {
+			loaded = append(loaded, s)
+			return io.NopCloser(strings.NewReader(`{"type": "string"}`)), nil
+		}
+		if err := c.AddResource("http://example.com/schema.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddResource("http://example.com/added.json", strings.NewReader(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tests := []struct {
+		ref          string
+		allowedHosts []string
+		valid        bool
+	}{
+		{"added.json", nil, true},
+		{"#/$defs/local", nil, true},
+		{"https://json-schema.org/draft/2020-12/schema", nil, true},
+		{"other.json", nil, false},
+		{"file:///tmp/other.json", nil, false},
+		{"https://schemas.example.org/other.json", nil, false},
+		{"https://schemas.example.org/other.json", []string{"SCHEMAS.example.org"}, true},
+		{"https://schemas.example.org:8443/other.json", []string{"schemas.example.org:8443"}, true},
+		{"https://schemas.example.org:8443/other.json", []string{"example.org"}, false},
+	}
+	for _, test := range tests {
+		loaded = nil
+		c := newCompiler(fmt.Sprintf(`{"$ref": %q, "$defs": {"local": {}}}`, test.ref))
+		c.AllowedHosts = test.allowedHosts
+		_, err := c.Compile("http://example.com/schema.json")
+		if test.valid != (err == nil) {
+			t.Errorf("%s: valid %t, got %v", test.ref, test.valid, err)
+		}
+		if !test.valid {
+			if len(loaded) > 0 {
+				t.Errorf("%s: must not be loaded", test.ref)
+			}
+			if err != nil && !strings.Contains(err.Error(), "not allowed") {
+				t.Errorf("%s: error must tell that loading is not allowed: %v", test.ref, err)
+			}
+		}
+	}
+
+	// root document is always loaded
+	c := jsonschema.NewCompiler()
+	c.AllowRemoteRefs = false
+	if _, err := c.Compile("testdata/person_schema.json"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConstEnumEquality(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {
+			"const": {"const": {"a": 1, "b": [1.0, {"c": null}]}},
+			"enum": {"enum": [{"a": 1, "b": 2}, [[1, 2], {"x": 10}], 100]},
+			"unique": {"uniqueItems": true}
+		}
+	}`)
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`{"const": {"b": [1, {"c": null}], "a": 1.0}}`, true},
+		{`{"const": {"b": [1, {"c": null}], "a": 1e0}}`, true},
+		{`{"const": {"b": [{"c": null}, 1], "a": 1}}`, false},
+		{`{"const": {"b": [1, {"c": null}], "a": 1, "d": 1}}`, false},
+		{`{"const": {"b": [1, {}], "a": 1}}`, false},
+		{`{"enum": {"b": 2, "a": 1}}`, true},
+		{`{"enum": {"b": 2.0, "a": 1}}`, true},
+		{`{"enum": {"b": "2", "a": 1}}`, false},
+		{`{"enum": [[1.0, 2], {"x": 1e1}]}`, true},
+		{`{"enum": [[2, 1], {"x": 10}]}`, false},
+		{`{"enum": 1e2}`, true},
+		{`{"enum": 100.0000000000000000001}`, false},
+		{`{"unique": [{"a": 1, "b": 2}, {"b": 2, "a": 1.0}]}`, false},
+		{`{"unique": [[1], [1.0]]}`, false},
+		{`{"unique": [[1], [1, 1]]}`, true},
+	}
+	for _, test := range tests {
+		err := sch.Validate(decodeString(t, test.doc))
+		if test.valid != (err == nil) {
+			t.Errorf("%s: valid %t, got %v", test.doc, test.valid, err)
+		}
+	}
+}
+
+func TestNumericPrecision(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {
+			"tenth": {"multipleOf": 0.1},
+			"cent": {"multipleOf": 0.01},
+			"min": {"minimum": 9007199254740993},
+			"max": {"exclusiveMaximum": 18446744073709551616},
+			"int": {"type": "integer"}
+		}
+	}`)
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`{"tenth": 0.3}`, true},
+		{`{"tenth": 0.7}`, true},
+		{`{"tenth": 0.35}`, false},
+		{`{"cent": 19.99}`, true},
+		{`{"cent": 1.005}`, false},
+		{`{"min": 9007199254740993}`, true},
+		{`{"min": 9007199254740992}`, false}, // equal as float64
+		{`{"max": 18446744073709551615}`, true},
+		{`{"max": 18446744073709551616}`, false},
+		{`{"int": 12345678901234567890}`, true},
+		{`{"int": 12345678901234567890.5}`, false},
+		{`{"int": 1.0e3}`, true},
+	}
+	for _, test := range tests {
+		err := sch.ValidateBytes([]byte(test.doc))
+		if test.valid != (err == nil) {
+			t.Errorf("%s: valid %t, got %v", test.doc, test.valid, err)
+		}
+	}
+
+	// go values
+	if err := sch.Validate(map[string]interface{}{"tenth": 0.3, "cent": float32(0.5)}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAdditionalPropertiesError(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {"name": true},
+		"patternProperties": {"^x-": true},
+		"additionalProperties": false
+	}`)
+	err := sch.Validate(decodeString(t, `{"name": 1, "x-ext": 2, "b": 3, "a/b": 4}`))
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		t.Fatalf("want ValidationError, got %v", err)
+	}
+	ve = causeAt(ve, "/additionalProperties")
+	if got, want := ve.Message.String(), `additionalProperties 'a/b', 'b' not allowed`; got != want {
+		t.Errorf("message: got %q, want %q", got, want)
+	}
+	var locs []string
+	for _, cause := range ve.Causes {
+		if cause.KeywordLocation != "/additionalProperties" {
+			t.Errorf("%s: keywordLocation: got %q", cause.InstanceLocation, cause.KeywordLocation)
+		}
+		locs = append(locs, cause.InstanceLocation)
+	}
+	if got, want := strings.Join(locs, " "), "/a~1b /b"; got != want {
+		t.Errorf("causes: got %q, want %q", got, want)
+	}
+}
+
+func TestPreserveOrder(t *testing.T) {
+	schema := `{
+		"properties": {
+			"zip": {"type": "string"},
+			"name": {
+				"properties": {"last": true, "first": true, "a/b": true}
+			},
+			"age": {"type": "integer"}
+		},
+		"required": ["zip", "age"]
+	}`
+	for _, preserve := range []bool{false, true} {
+		c := jsonschema.NewCompiler()
+		c.PreserveOrder = preserve
+		if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		sch, err := c.Compile("schema.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !preserve {
+			if sch.PropertyOrder != nil {
+				t.Errorf("PropertyOrder: got %v, want nil", sch.PropertyOrder)
+			}
+			continue
+		}
+		if got, want := strings.Join(sch.PropertyOrder, " "), "zip name age"; got != want {
+			t.Errorf("PropertyOrder: got %q, want %q", got, want)
+		}
+		if got, want := strings.Join(sch.Properties["name"].PropertyOrder, " "), "last first a/b"; got != want {
+			t.Errorf("name.PropertyOrder: got %q, want %q", got, want)
+		}
+		if got, want := strings.Join(sch.Required, " "), "zip age"; got != want {
+			t.Errorf("Required: got %q, want %q", got, want)
+		}
+	}
+
+	// order unknown for AddResourceJSON
+	c := jsonschema.NewCompiler()
+	c.PreserveOrder = true
+	if err := c.AddResourceJSON("schema.json", decodeString(t, schema)); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := c.Compile("schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(sch.PropertyOrder, " "), "age name zip"; got != want {
+		t.Errorf("PropertyOrder: got %q, want %q", got, want)
+	}
+}
+
+func TestValidateAt(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {
+			"user": {"$ref": "#/$defs/user"},
+			"tags": {"items": {"type": "string"}}
+		},
+		"$defs": {
+			"user": {
+				"required": ["name"],
+				"properties": {"name": {"type": "string"}}
+			}
+		}
+	}`)
+	tests := []struct {
+		ptr   string
+		doc   string
+		valid bool
+	}{
+		{"", `{"tags": ["a"]}`, true},
+		{"/properties/user", `{"name": "john"}`, true},
+		{"/properties/user", `{}`, false},
+		{"#/properties/user/properties/name", `1`, false},
+		{"/properties/tags/items", `"a"`, true},
+		{"/properties/tags/items", `1`, false},
+		{"#/$defs/user", `{"name": "john"}`, true},
+		{"#/$defs/user", `{"name": 1}`, false},
+		{"/$defs/user/properties/name", `"john"`, true},
+	}
+	for _, test := range tests {
+		err := sch.ValidateAt(test.ptr, strings.NewReader(test.doc))
+		if test.valid != (err == nil) {
+			t.Errorf("%q %s: valid %t, got %v", test.ptr, test.doc, test.valid, err)
+		}
+		if err != nil {
+			if _, ok := err.(*jsonschema.ValidationError); !ok {
+				t.Errorf("%q %s: want ValidationError, got %#v", test.ptr, test.doc, err)
+			}
+		}
+	}
+
+	if err := sch.ValidateAt("/properties/missing", strings.NewReader(`{}`)); err == nil {
+		t.Error("want error for missing subschema")
+	} else if _, ok := err.(*jsonschema.ValidationError); ok {
+		t.Errorf("want lookup error, got %v", err)
+	}
+	if err := sch.ValidateAt("/properties/user", strings.NewReader(`{`)); err == nil {
+		t.Error("want error for invalid json")
+	}
+}
+
+func TestIfThenElseError(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {
+			"both": {
+				"if": {"type": "string"},
+				"then": {"minLength": 3},
+				"else": {"minimum": 10}
+			},
+			"noElse": {
+				"if": {"type": "string"},
+				"then": {"minLength": 3}
+			}
+		}
+	}`)
+	tests := []struct {
+		doc     string
+		kloc    string // keywordLocation of if-branch error, empty if valid
+		message string
+		cause   string // keywordLocation of nested cause
+	}{
+		{`{"both": "abc"}`, "", "", ""},
+		{`{"both": 10}`, "", "", ""},
+		{`{"both": "ab"}`, "/properties/both/then", "if matched, but then failed", "/properties/both/then/minLength"},
+		{`{"both": 5}`, "/properties/both/else", "if did not match, and else failed", "/properties/both/else/minimum"},
+		{`{"noElse": 5}`, "", "", ""},
+		{`{"noElse": "ab"}`, "/properties/noElse/then", "if matched, but then failed", "/properties/noElse/then/minLength"},
+	}
+	for _, test := range tests {
+		err := sch.Validate(decodeString(t, test.doc))
+		if test.kloc == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.doc, err)
+			}
+			continue
+		}
+		ve, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			t.Errorf("%s: want ValidationError, got %v", test.doc, err)
+			continue
+		}
+		ve = causeAt(ve, test.kloc)
+		if ve.KeywordLocation != test.kloc {
+			t.Errorf("%s: keywordLocation: got %q, want %q", test.doc, ve.KeywordLocation, test.kloc)
+			continue
+		}
+		if got := ve.Message.String(); got != test.message {
+			t.Errorf("%s: message: got %q, want %q", test.doc, got, test.message)
+		}
+		if len(ve.Causes) != 1 || ve.Causes[0].KeywordLocation != test.cause {
+			t.Errorf("%s: causes: got %v, want %q", test.doc, ve.Causes, test.cause)
+		}
+	}
+}
+
+func TestExamples(t *testing.T) {
+	drafts := []string{
+		"http://json-schema.org/draft-06/schema#",
+		"http://json-schema.org/draft-07/schema#",
+		"https://json-schema.org/draft/2020-12/schema",
+	}
+	for _, draft := range drafts {
+		c := jsonschema.NewCompiler()
+		c.ExtractAnnotations = true
+		schema := `{
+			"$schema": "` + draft + `",
+			"examples": ["str", 12345678901234567890, null, true, {"a": [1, {"b": 2.5}]}]
+		}`
+		if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		sch, err := c.Compile("schema.json")
+		if err != nil {
+			t.Fatalf("%s: %v", draft, err)
+		}
+		b, err := json.Marshal(sch.Examples)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), `["str",12345678901234567890,null,true,{"a":[1,{"b":2.5}]}]`; got != want {
+			t.Errorf("%s: got %s, want %s", draft, got, want)
+		}
+
+		// examples must be array
+		c = jsonschema.NewCompiler()
+		c.ExtractAnnotations = true
+		schema = strings.Replace(schema, `"examples": [`, `"examples": "str", "x": [`, 1)
+		if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Compile("schema.json"); err == nil {
+			t.Errorf("%s: want error for non-array examples", draft)
+		}
+	}
+
+	// examples is not a keyword in draft4
+	c := jsonschema.NewCompiler()
+	c.ExtractAnnotations = true
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"$schema": "http://json-schema.org/draft-04/schema#",
+		"examples": "str"
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := c.Compile("schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sch.Examples != nil {
+		t.Errorf("draft4: got examples %v", sch.Examples)
+	}
+}
+
+func TestPatternError(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {"code": {"pattern": "^[A-Z]{3}$"}},
+		"patternProperties": {"^x-": {"type": "string"}}
+	}`)
+
+	err := sch.Validate(decodeString(t, `{"code": "ab1"}`))
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		t.Fatalf("want ValidationError, got %v", err)
+	}
+	leaf := ve.LeafErrors()[0]
+	if m, ok := leaf.Message.(msg.Pattern); !ok || m.Got != "ab1" || m.Want != "^[A-Z]{3}$" {
+		t.Errorf("pattern: got %#v", leaf.Message)
+	}
+
+	err = sch.Validate(decodeString(t, `{"x-a/b": 1}`))
+	ve, ok = err.(*jsonschema.ValidationError)
+	if !ok {
+		t.Fatalf("want ValidationError, got %v", err)
+	}
+	ve = causeAt(ve, "/patternProperties/^x-")
+	if m, ok := ve.Message.(msg.PatternProperties); !ok || m.Got != "x-a/b" || m.Want != "^x-" {
+		t.Fatalf("patternProperties: got %#v", ve.Message)
+	}
+	if ve.KeywordLocation != "/patternProperties/^x-" || ve.InstanceLocation != "/x-a~1b" {
+		t.Errorf("patternProperties: got %q at %q", ve.KeywordLocation, ve.InstanceLocation)
+	}
+	if len(ve.Causes) != 1 || ve.Causes[0].KeywordLocation != "/patternProperties/^x-/type" {
+		t.Errorf("patternProperties: got causes %v", ve.Causes)
+	}
+}
+
+func TestComment(t *testing.T) {
+	schema := `{
+		"$comment": "root",
+		"properties": {
+			"name": {"$comment": "must not be empty", "minLength": 1},
+			"tags": {"items": {"$comment": "tag", "type": "string"}}
+		}
+	}`
+	for _, extract := range []bool{false, true} {
+		c := jsonschema.NewCompiler()
+		c.ExtractAnnotations = extract
+		if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		sch := c.MustCompile("schema.json")
+
+		var comments []string
+		sch.Walk(func(path string, s *jsonschema.Schema) bool {
+			if s.Comment != "" {
+				comments = append(comments, path+"="+s.Comment)
+			}
+			return true
+		})
+		want := ""
+		if extract {
+			want = "=root /properties/name=must not be empty /properties/tags/items=tag"
+		}
+		if got := strings.Join(comments, " "); got != want {
+			t.Errorf("extract=%t: got %q, want %q", extract, got, want)
+		}
+
+		// $comment does not affect validation
+		if err := sch.Validate(decodeString(t, `{"name": "x", "tags": ["a"]}`)); err != nil {
+			t.Errorf("extract=%t: %v", extract, err)
+		}
+		if err := sch.Validate(decodeString(t, `{"name": ""}`)); err == nil {
+			t.Errorf("extract=%t: want error", extract)
+		}
+	}
+}
+
+type celsius float64
+
+func (c celsius) MarshalJSON() ([]byte, error) {
+	if c < -273.15 {
+		return nil, errors.New("below absolute zero")
+	}
+	return json.Marshal(map[string]interface{}{"unit": "C", "value": float64(c)})
+}
+
+func TestMarshalUnknownTypes(t *testing.T) {
+	schema := `{
+		"properties": {
+			"at": {"type": "string", "format": "date-time"},
+			"temp": {
+				"required": ["unit", "value"],
+				"properties": {"value": {"minimum": -100}}
+			}
+		}
+	}`
+	at := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	c.MarshalUnknownTypes = true
+	if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+		t.Fatal(err)
+	}
+	sch := c.MustCompile("schema.json")
+
+	tests := []struct {
+		doc   interface{}
+		valid bool
+	}{
+		{map[string]interface{}{"at": at, "temp": celsius(20)}, true},
+		{map[string]interface{}{"temp": celsius(-150)}, false},
+		{struct {
+			At   time.Time `json:"at"`
+			Temp celsius   `json:"temp"`
+		}{at, 20}, true},
+		{struct {
+			At int `json:"at"`
+		}{1}, false},
+	}
+	for i, test := range tests {
+		err := sch.Validate(test.doc)
+		if test.valid != (err == nil) {
+			t.Errorf("#%d: valid %t, got %v", i, test.valid, err)
+		}
+		if err != nil {
+			if _, ok := err.(*jsonschema.ValidationError); !ok {
+				t.Errorf("#%d: want ValidationError, got %#v", i, err)
+			}
+		}
+	}
+
+	// marshal failure
+	err := sch.Validate(map[string]interface{}{"temp": celsius(-300)})
+	if err, ok := err.(jsonschema.InvalidJSONTypeError); !ok || string(err) != "jsonschema_test.celsius" {
+		t.Errorf("got %#v, want InvalidJSONTypeError", err)
+	}
+
+	// without MarshalUnknownTypes
+	c = jsonschema.NewCompiler()
+	if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.MustCompile("schema.json").Validate(map[string]interface{}{"at": at}); err == nil {
+		t.Error("want InvalidJSONTypeError")
+	} else if _, ok := err.(jsonschema.InvalidJSONTypeError); !ok {
+		t.Errorf("got %#v, want InvalidJSONTypeError", err)
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"required": ["id", "a/b"],
+		"properties": {
+			"name": {"type": "string", "allOf": [{"minLength": 2}, {"allOf": [{"pattern": "^[a-z]+$"}]}]},
+			"tags": {"items": {"type": "string"}},
+			"address": {
+				"required": ["zip"],
+				"properties": {"city": {"minLength": 1}}
+			}
+		},
+		"additionalProperties": false
+	}`)
+	err := sch.Validate(decodeString(t, `{
+		"name": "",
+		"tags": ["a", 1],
+		"address": {"city": ""},
+		"extra": true
+	}`))
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		t.Fatalf("want ValidationError, got %v", err)
+	}
+	got := ve.FieldErrors()
+	want := map[string]string{
+		"/id":           "missing properties: id",
+		"/a~1b":         "missing properties: a/b",
+		"/name":         "'' does not match pattern '^[a-z]+$'",
+		"/tags/1":       "expected string, but got number",
+		"/address/zip":  "missing properties: zip",
+		"/address/city": "length must be >= 1, but got 0",
+		"/extra":        "additionalProperties 'extra' not allowed",
+	}
+	for vloc, msg := range want {
+		if got[vloc] != msg {
+			t.Errorf("%s: got %q, want %q", vloc, got[vloc], msg)
+		}
+	}
+	for vloc := range got {
+		if _, ok := want[vloc]; !ok {
+			t.Errorf("%s: unexpected %q", vloc, got[vloc])
+		}
+	}
+}
+
+func TestVocabulary(t *testing.T) {
+	meta := `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id": "http://example.com/meta",
+		"$vocabulary": {
+			"https://json-schema.org/draft/2020-12/vocab/core": true,
+			"https://json-schema.org/draft/2020-12/vocab/applicator": true,
+			"https://json-schema.org/draft/2020-12/vocab/validation": false,
+			"http://example.com/vocab/optional": false
+		},
+		"$dynamicAnchor": "meta",
+		"allOf": [
+			{"$ref": "https://json-schema.org/draft/2020-12/meta/core"},
+			{"$ref": "https://json-schema.org/draft/2020-12/meta/applicator"}
+		]
+	}`
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("http://example.com/meta", strings.NewReader(meta)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"$schema": "http://example.com/meta",
+		"minimum": 10,
+		"properties": {"a": false}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := c.Compile("schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sch.Minimum != nil {
+		t.Errorf("minimum of disabled vocab must not be compiled")
+	}
+	if err := sch.Validate(decodeString(t, `5`)); err != nil {
+		t.Errorf("minimum of disabled vocab must not be asserted: %v", err)
+	}
+	if err := sch.Validate(decodeString(t, `{"a": 1}`)); err == nil {
+		t.Error("properties of enabled vocab must be asserted")
+	}
+
+	// unknown required vocab
+	c = jsonschema.NewCompiler()
+	meta = strings.Replace(meta, `"http://example.com/vocab/optional": false`, `"http://example.com/vocab/required": true`, 1)
+	if err := c.AddResource("http://example.com/meta", strings.NewReader(meta)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddResource("schema.json", strings.NewReader(`{"$schema": "http://example.com/meta"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Compile("schema.json"); err == nil || !strings.Contains(err.Error(), "unsupported vocab") {
+		t.Errorf("got %v, want unsupported vocab error", err)
+	}
+}
+
+func TestUseNumber(t *testing.T) {
+	schema := `{
+		"properties": {
+			"count": {"type": "integer"},
+			"big": {"minimum": 9007199254740993}
+		}
+	}`
+	tests := []struct {
+		doc       string
+		useNumber bool
+		valid     bool
+	}{
+		{`{"count": 10}`, true, true},
+		{`{"count": 10}`, false, true},
+		{`{"count": 10.0}`, false, true}, // whole valued float is integer
+		{`{"count": 1e3}`, false, true},
+		{`{"count": 10.5}`, false, false},
+		{`{"big": 9007199254740993}`, true, true},
+		{`{"big": 9007199254740993}`, false, false}, // precision lost
+	}
+	for i, test := range tests {
+		c := jsonschema.NewCompiler()
+		c.UseNumber = test.useNumber
+		if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		sch := c.MustCompile("schema.json")
+		if err := sch.ValidateBytes([]byte(test.doc)); test.valid != (err == nil) {
+			t.Errorf("#%d: valid %t, got %v", i, test.valid, err)
+		}
+	}
+	if !jsonschema.NewCompiler().UseNumber {
+		t.Error("UseNumber must default to true")
+	}
+}
+
+func TestRefCycle(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		cycle string // suffix of InfiniteLoopError, empty if valid recursion
+	}{
+		{`{"$ref": "b.json"}`, `{"$ref": "a.json"}`, "a.json#/$ref/$ref"},
+		{`{"$ref": "b.json"}`, `{"allOf": [{"$ref": "a.json#"}]}`, "a.json#/$ref/allOf/0/$ref"},
+		{`{"$ref": "b.json"}`, `{"$ref": "#/$defs/x", "$defs": {"x": {"$ref": "a.json"}}}`, "a.json#/$ref/$ref/$ref"},
+		{`{"properties": {"b": {"$ref": "b.json"}}}`, `{"items": {"$ref": "a.json"}}`, ""},
+		{`{"$ref": "b.json"}`, `{"properties": {"next": {"$ref": "a.json"}}}`, ""},
+		{`{"$ref": "b.json#/$defs/x"}`, `{"$ref": "a.json", "$defs": {"x": {"type": "object"}}}`, ""},
+	}
+	for i, test := range tests {
+		c := jsonschema.NewCompiler()
+		if err := c.AddResource("http://example.com/a.json", strings.NewReader(test.a)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddResource("http://example.com/b.json", strings.NewReader(test.b)); err != nil {
+			t.Fatal(err)
+		}
+		_, err := c.Compile("http://example.com/a.json")
+		if test.cycle == "" {
+			if err != nil {
+				t.Errorf("#%d: %v", i, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("#%d: want error", i)
+			continue
+		}
+		se, ok := err.(*jsonschema.SchemaError)
+		if !ok {
+			t.Errorf("#%d: want SchemaError, got %#v", i, err)
+			continue
+		}
+		if loop, ok := se.Err.(jsonschema.InfiniteLoopError); !ok || !strings.HasSuffix(string(loop), test.cycle) {
+			t.Errorf("#%d: got %v, want infinite loop %s", i, se.Err, test.cycle)
+		}
+	}
+}
+
+func TestAnchorAcrossResources(t *testing.T) {
+	resources := map[string]string{
+		"http://example.com/strings.json": `{
+			"$schema": "https://json-schema.org/draft/2019-09/schema",
+			"$defs": {
+				"name": {"$anchor": "name", "type": "string"},
+				"nested": {
+					"$id": "nested/",
+					"$defs": {"code": {"$anchor": "code", "pattern": "^[A-Z]+$"}}
+				}
+			}
+		}`,
+		"http://example.com/ints.json": `{
+			"$schema": "http://json-schema.org/draft-07/schema#",
+			"definitions": {"count": {"$id": "#count", "type": "integer"}}
+		}`,
+		"http://example.com/main.json": `{
+			"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"properties": {
+				"name": {"$ref": "strings.json#name"},
+				"code": {"$ref": "nested/#code"},
+				"count": {"$ref": "ints.json#count"}
+			}
+		}`,
+	}
+	c := jsonschema.NewCompiler()
+	for url, schema := range resources {
+		if err := c.AddResource(url, strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// nested/ is embedded in strings.json, so it must be loaded first
+	if _, err := c.Compile("http://example.com/strings.json"); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := c.Compile("http://example.com/main.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`{"name": "john", "code": "ABC", "count": 1}`, true},
+		{`{"name": 1}`, false},
+		{`{"code": "abc"}`, false},
+		{`{"count": "1"}`, false},
+	}
+	for _, test := range tests {
+		if err := sch.Validate(decodeString(t, test.doc)); test.valid != (err == nil) {
+			t.Errorf("%s: valid %t, got %v", test.doc, test.valid, err)
+		}
+	}
+
+	// anchor not found
+	if err := c.AddResource("http://example.com/bad.json", strings.NewReader(`{"$ref": "strings.json#missing"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Compile("http://example.com/bad.json"); err == nil {
+		t.Error("want error for missing anchor")
+	}
+}
+
+func TestBooleanSchema(t *testing.T) {
+	for _, schema := range []string{"true", "false"} {
+		c := jsonschema.NewCompiler()
+		if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		sch, err := c.Compile("schema.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range []string{`null`, `1`, `"x"`, `[]`, `{"x": 1}`} {
+			err := sch.Validate(decodeString(t, doc))
+			if schema == "true" {
+				if err != nil {
+					t.Errorf("%s: %s: %v", schema, doc, err)
+				}
+				continue
+			}
+			ve, ok := err.(*jsonschema.ValidationError)
+			if !ok {
+				t.Errorf("%s: %s: want ValidationError, got %v", schema, doc, err)
+				continue
+			}
+			leaf := ve.LeafErrors()[0]
+			if _, ok := leaf.Message.(msg.False); !ok || leaf.Message.String() != "not allowed" {
+				t.Errorf("%s: %s: got %#v", schema, doc, leaf.Message)
+			}
+		}
+	}
+
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {"x": false, "y": true},
+		"items": false
+	}`)
+	tests := []struct {
+		doc   string
+		valid bool
+		vloc  string
+	}{
+		{`{}`, true, ""},
+		{`{"y": 1}`, true, ""},
+		{`{"x": null}`, false, "/x"},
+		{`[]`, true, ""},
+		{`[1]`, false, "/0"},
+	}
+	for _, test := range tests {
+		err := sch.Validate(decodeString(t, test.doc))
+		if test.valid != (err == nil) {
+			t.Errorf("%s: valid %t, got %v", test.doc, test.valid, err)
+			continue
+		}
+		if err != nil {
+			if leaf := err.(*jsonschema.ValidationError).LeafErrors()[0]; leaf.InstanceLocation != test.vloc {
+				t.Errorf("%s: got instanceLocation %q, want %q", test.doc, leaf.InstanceLocation, test.vloc)
+			}
+		}
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"properties": {
+			"any": {"anyOf": [{"type": "string"}, {"minimum": 10}]},
+			"one": {"oneOf": [{"type": "integer"}, {"minimum": 10}]},
+			"not": {"not": {"type": "null"}},
+			"cond": {"if": {"type": "string"}, "then": {"minLength": 2}, "else": {"type": "integer"}},
+			"list": {"contains": {"const": 1}, "minContains": 2},
+			"tree": {"$ref": "#"}
+		},
+		"unevaluatedProperties": false
+	}`)
+	tests := []string{
+		`{}`,
+		`{"any": "x"}`,
+		`{"any": 20}`,
+		`{"any": 5}`,
+		`{"one": 5}`,
+		`{"one": 20}`,
+		`{"one": 20.5}`,
+		`{"not": 1}`,
+		`{"not": null}`,
+		`{"cond": "ab"}`,
+		`{"cond": "a"}`,
+		`{"cond": 1}`,
+		`{"cond": 1.5}`,
+		`{"list": [1, 2, 1]}`,
+		`{"list": [1, 2]}`,
+		`{"tree": {"tree": {"any": 20}}}`,
+		`{"tree": {"tree": {"any": 5}}}`,
+		`{"extra": 1}`,
+		`{`,
+	}
+	for _, doc := range tests {
+		want := sch.ValidateBytes([]byte(doc)) == nil
+		if got := sch.IsValid(strings.NewReader(doc)); got != want {
+			t.Errorf("%s: got %t, want %t", doc, got, want)
+		}
+	}
+}
+
+func BenchmarkIsValid(b *testing.B) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"type": "array",
+		"items": {
+			"type": "object",
+			"required": ["id", "name"],
+			"properties": {
+				"id": {"type": "integer"},
+				"name": {"type": "string", "minLength": 1},
+				"tags": {"type": "array", "items": {"type": "string"}}
+			}
+		}
+	}`)
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, `{"id": "%d", "name": "", "tags": [1, 2]}`, i)
+	}
+	buf.WriteString("]")
+	doc := buf.Bytes()
+	b.Run("ValidateBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if sch.ValidateBytes(doc) == nil {
+				b.Fatal("want error")
+			}
+		}
+	})
+	b.Run("IsValid", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if sch.IsValid(bytes.NewReader(doc)) {
+				b.Fatal("want invalid")
+			}
+		}
+	})
+}
+
+func TestDependentKeywords(t *testing.T) {
+	tests := []struct {
+		schema string
+		doc    string
+		valid  bool
+	}{
+		// dependentRequired
+		{`{"dependentRequired": {"bar": ["foo"]}}`, `{}`, true},
+		{`{"dependentRequired": {"bar": ["foo"]}}`, `{"foo": 1}`, true},
+		{`{"dependentRequired": {"bar": ["foo"]}}`, `{"foo": 1, "bar": 2}`, true},
+		{`{"dependentRequired": {"bar": ["foo"]}}`, `{"bar": 2}`, false},
+		{`{"dependentRequired": {"bar": ["foo"]}}`, `["bar"]`, true},
+		{`{"dependentRequired": {"bar": []}}`, `{"bar": 2}`, true},
+		// dependentSchemas
+		{`{"dependentSchemas": {"bar": {"properties": {"foo": {"type": "integer"}}}}}`, `{"foo": "x"}`, true},
+		{`{"dependentSchemas": {"bar": {"properties": {"foo": {"type": "integer"}}}}}`, `{"foo": 1, "bar": 2}`, true},
+		{`{"dependentSchemas": {"bar": {"properties": {"foo": {"type": "integer"}}}}}`, `{"foo": "x", "bar": 2}`, false},
+		{`{"dependentSchemas": {"bar": false}}`, `{"bar": 2}`, false},
+		// legacy dependencies, still honored for compatibility
+		{`{"dependencies": {"bar": ["foo"]}}`, `{"bar": 2}`, false},
+		{`{"dependencies": {"bar": {"required": ["foo"]}}}`, `{"bar": 2}`, false},
+	}
+	for _, draft := range []string{"https://json-schema.org/draft/2019-09/schema", "https://json-schema.org/draft/2020-12/schema"} {
+		for _, test := range tests {
+			schema := `{"$schema": "` + draft + `", ` + test.schema[1:]
+			sch, err := jsonschema.CompileString("schema.json", schema)
+			if err != nil {
+				t.Fatalf("%s: %v", schema, err)
+			}
+			if err := sch.Validate(decodeString(t, test.doc)); test.valid != (err == nil) {
+				t.Errorf("%s: %s: valid %t, got %v", schema, test.doc, test.valid, err)
+			}
+		}
+	}
+
+	// not keywords in draft7
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"dependentRequired": {"bar": ["foo"]},
+		"dependentSchemas": {"bar": false}
+	}`)
+	if err := sch.Validate(decodeString(t, `{"bar": 1}`)); err != nil {
+		t.Errorf("draft7: %v", err)
+	}
+}
+
+func TestMinMaxContains(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {
+			"range": {"contains": {"type": "integer"}, "minContains": 2, "maxContains": 4},
+			"zero": {"contains": {"type": "integer"}, "minContains": 0},
+			"max": {"contains": {"type": "integer"}, "maxContains": 1}
+		}
+	}`)
+	tests := []struct {
+		doc     string
+		message string // empty if valid
+	}{
+		{`{"range": [1, "a", 2]}`, ""},
+		{`{"range": [1, 2, 3, 4]}`, ""},
+		{`{"range": [1, "a"]}`, "minimum 2 valid items required, but found 1 valid items"},
+		{`{"range": ["a"]}`, "minimum 2 valid items required, but found 0 valid items"},
+		{`{"range": [1, 2, 3, 4, 5]}`, "maximum 4 valid items allowed, but found 5 valid items"},
+		{`{"zero": []}`, ""},
+		{`{"zero": ["a"]}`, ""},
+		{`{"max": [1]}`, ""},
+		{`{"max": ["a"]}`, "minimum 1 valid items required, but found 0 valid items"},
+		{`{"max": [1, 2]}`, "maximum 1 valid items allowed, but found 2 valid items"},
+	}
+	for _, test := range tests {
+		err := sch.Validate(decodeString(t, test.doc))
+		if test.message == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.doc, err)
+			}
+			continue
+		}
+		ve, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			t.Errorf("%s: want ValidationError, got %v", test.doc, err)
+			continue
+		}
+		var messages []string
+		for _, leaf := range ve.LeafErrors() {
+			if kw := leaf.MessageKey(); kw == "minContains" || kw == "maxContains" {
+				messages = append(messages, leaf.Message.String())
+			}
+		}
+		if len(messages) == 0 {
+			// minContains error has the contains failures as causes
+			for e := ve; len(e.Causes) > 0; e = e.Causes[0] {
+				if kw := e.MessageKey(); kw == "minContains" {
+					messages = append(messages, e.Message.String())
+				}
+			}
+		}
+		if len(messages) != 1 || messages[0] != test.message {
+			t.Errorf("%s: got %q, want %q", test.doc, messages, test.message)
+		}
+	}
+}
+
+func TestCompilerClone(t *testing.T) {
+	base := jsonschema.NewCompiler()
+	if err := base.AddResource("schema.json", strings.NewReader(`{
+		"properties": {"at": {"format": "date"}}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	// compile before cloning, so that clone must compile again
+	sch := base.MustCompile("schema.json")
+
+	clone := base.Clone()
+	clone.AssertFormat = true
+	clone.Formats["odd"] = func(v interface{}) bool { return false }
+	if err := clone.AddResource("other.json", strings.NewReader(`{"format": "odd"}`)); err != nil {
+		t.Fatal(err)
+	}
+	csch := clone.MustCompile("schema.json")
+
+	doc := decodeString(t, `{"at": "not-date"}`)
+	if err := sch.Validate(doc); err != nil {
+		t.Errorf("base: %v", err)
+	}
+	err := csch.Validate(doc)
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		t.Fatalf("clone: want ValidationError, got %v", err)
+	}
+	if leaves := ve.LeafErrors(); len(leaves) != 1 || leaves[0].InstanceLocation != "/at" {
+		t.Errorf("clone: got %v", leaves)
+	}
+
+	if base.AssertFormat {
+		t.Error("base: AssertFormat changed")
+	}
+	if _, ok := base.Formats["odd"]; ok {
+		t.Error("base: Formats changed")
+	}
+	if _, err := base.Compile("other.json"); err == nil {
+		t.Error("base: resource added to clone must not be available")
+	}
+	if _, err := clone.Compile("other.json"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompileReader(t *testing.T) {
+	sch, err := jsonschema.CompileReader(strings.NewReader(`{
+		"$ref": "#/$defs/positive",
+		"$defs": {"positive": {"type": "integer", "exclusiveMinimum": 0}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sch.Validate(decodeString(t, `1`)); err != nil {
+		t.Error(err)
+	}
+	if err := sch.Validate(decodeString(t, `0`)); err == nil {
+		t.Error("want error")
+	}
+
+	// draft metaschemas are available
+	if _, err := jsonschema.CompileReader(strings.NewReader(`{"$schema": "http://json-schema.org/draft-07/schema#"}`)); err != nil {
+		t.Error(err)
+	}
+
+	for _, schema := range []string{`{"$ref": "other.json"}`, `{"$ref": "http://example.com/other.json"}`, `{`} {
+		if _, err := jsonschema.CompileReader(strings.NewReader(schema)); err == nil {
+			t.Errorf("%s: want error", schema)
+		}
+	}
+}
+
+func TestDependencyErrors(t *testing.T) {
+	for _, kw := range []string{"dependencies", "dependentSchemas"} {
+		// dependencies has both forms in same keyword
+		deps := `"dependentRequired": {"card": ["billing"]}, "dependentSchemas": {`
+		if kw == "dependencies" {
+			deps = `"dependencies": {"card": ["billing"],`
+		}
+		sch := jsonschema.MustCompileString("schema.json", `{
+			`+deps+`
+				"ship": {"required": ["address"]},
+				"gift": {"properties": {"note": {"maxLength": 3}}}
+			}
+		}`)
+		err := sch.Validate(decodeString(t, `{"card": 1, "ship": true, "gift": true, "note": "hello"}`))
+		ve, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			t.Fatalf("%s: want ValidationError, got %v", kw, err)
+		}
+		got := map[string]string{}
+		for _, cause := range ve.Causes {
+			var leaves []string
+			for _, leaf := range cause.LeafErrors() {
+				leaves = append(leaves, leaf.KeywordLocation)
+			}
+			got[cause.Message.String()] = strings.Join(leaves, " ")
+		}
+		want := map[string]string{
+			"property 'billing' is required, if 'card' property exists": "/" + strings.Replace(kw, "Schemas", "Required", 1) + "/card/0",
+			"dependent schema of property 'ship' failed":                "/" + kw + "/ship/required",
+			"dependent schema of property 'gift' failed":                "/" + kw + "/gift/properties/note/maxLength",
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got %v", kw, got)
+		}
+		for m, leaves := range want {
+			if got[m] != leaves {
+				t.Errorf("%s: %s: got %q, want %q", kw, m, got[m], leaves)
+			}
+		}
+	}
+}
+
+func TestValidateAll(t *testing.T) {
+	schemas := []*jsonschema.Schema{
+		jsonschema.MustCompileString("a.json", `{"required": ["id"]}`),
+		jsonschema.MustCompileString("b.json", `{"properties": {"id": {"type": "integer"}}}`),
+		jsonschema.MustCompileString("c.json", `{"maxProperties": 2}`),
+	}
+	if err := jsonschema.ValidateAll(strings.NewReader(`{"id": 1}`), schemas...); err != nil {
+		t.Fatal(err)
+	}
+
+	r := iotest.OneByteReader(strings.NewReader(`{"id": "x", "a": 1, "b": 2}`))
+	err := jsonschema.ValidateAll(r, schemas...)
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		t.Fatalf("want ValidationError, got %v", err)
+	}
+	if m, ok := ve.Message.(msg.Schemas); !ok || len(m.Got) != 2 || m.Got[0] != schemas[1].Location || m.Got[1] != schemas[2].Location {
+		t.Errorf("got message %#v", ve.Message)
+	}
+	if ve.AbsoluteKeywordLocation != "" || ve.KeywordLocation != "" {
+		t.Errorf("got keyword location %q %q, want empty", ve.KeywordLocation, ve.AbsoluteKeywordLocation)
+	}
+	if len(ve.Causes) != 2 {
+		t.Fatalf("got %d causes, want 2", len(ve.Causes))
+	}
+	for i, want := range []string{"b.json#", "c.json#"} {
+		if got := ve.Causes[i].AbsoluteKeywordLocation; !strings.HasSuffix(got, want) {
+			t.Errorf("cause %d: got %q, want suffix %q", i, got, want)
+		}
+	}
+	if e := err.Error(); !strings.Contains(e, "doesn't validate with schemas") || !strings.Contains(e, "b.json#/properties/id/type") || !strings.Contains(e, "expected integer") {
+		t.Errorf("got %q", e)
+	}
+
+	if err := jsonschema.ValidateAll(strings.NewReader(`{`), schemas...); err == nil {
+		t.Error("want error for invalid json")
+	}
+	if err := jsonschema.ValidateAll(strings.NewReader(`1`)); err != nil {
+		t.Errorf("no schemas: %v", err)
+	}
+}
+
+func TestTrackPositions(t *testing.T) {
+	schema := `{
+		"properties": {
+			"name": {"type": "string"},
+			"tags": {"items": {"type": "string"}},
+			"nested": {"properties": {"a/b": {"items": {"items": {"minimum": 0}}}}}
+		},
+		"additionalProperties": false
+	}`
+	doc := "{\n" +
+		"  \"name\": 1,\n" +
+		"  \"tags\": [\"a\", 2],\n" +
+		"  \"nested\": {\"a/b\": [[1], [2, -3]]},\n" +
+		"  \"extra\" :\t{}\n" +
+		"}"
+	c := jsonschema.NewCompiler()
+	c.TrackPositions = true
+	if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+		t.Fatal(err)
+	}
+	sch := c.MustCompile("schema.json")
+
+	want := map[string]jsonschema.Position{
+		"/name":            {Offset: 12, Line: 2, Column: 11},
+		"/tags/1":          {Offset: 31, Line: 3, Column: 17},
+		"/nested/a~1b/1/1": {Offset: 65, Line: 4, Column: 31},
+		"/extra":           {Offset: 84, Line: 5, Column: 13},
+	}
+	for _, validate := range []func() error{
+		func() error { return sch.ValidateBytes([]byte(doc)) },
+		func() error { return sch.ValidateAt("", strings.NewReader(doc)) },
+		func() error { return sch.Validator().ValidateBytes([]byte(doc)) },
+	} {
+		ve, ok := validate().(*jsonschema.ValidationError)
+		if !ok {
+			t.Fatal("want ValidationError")
+		}
+		if ve.Position == nil || *ve.Position != (jsonschema.Position{Offset: 0, Line: 1, Column: 1}) {
+			t.Errorf("root: got %v", ve.Position)
+		}
+		leaves := ve.LeafErrors()
+		if len(leaves) != len(want) {
+			t.Errorf("got %d leaves, want %d", len(leaves), len(want))
+		}
+		for _, leaf := range leaves {
+			if leaf.Position == nil {
+				t.Errorf("%s: no position", leaf.InstanceLocation)
+			} else if *leaf.Position != want[leaf.InstanceLocation] {
+				t.Errorf("%s: got %+v, want %+v", leaf.InstanceLocation, *leaf.Position, want[leaf.InstanceLocation])
+			}
+		}
+	}
+
+	// positions are not tracked by default, nor for decoded values
+	ve := jsonschema.MustCompileString("schema.json", schema).ValidateBytes([]byte(doc)).(*jsonschema.ValidationError)
+	if ve.Position != nil {
+		t.Errorf("got %v, want nil", ve.Position)
+	}
+	ve = sch.Validate(decodeString(t, doc)).(*jsonschema.ValidationError)
+	if ve.Position != nil {
+		t.Errorf("got %v, want nil", ve.Position)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"type": "object",
+		"properties": {
+			"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150.5},
+			"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}, "uniqueItems": true},
+			"child": {"$ref": "#"}
+		},
+		"required": ["age"],
+		"additionalProperties": false
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch := c.MustCompile("schema.json")
+	b, err := json.Marshal(sch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"$schema":"https://json-schema.org/draft/2020-12/schema","additionalProperties":false,` +
+		`"properties":{"age":{"exclusiveMaximum":150.5,"minimum":0,"type":"integer"},` +
+		`"child":{"$ref":"` + sch.Location + `"},"tags":{"items":{"pattern":"^[a-z]+$","type":"string"},"type":"array","uniqueItems":true}},` +
+		`"required":["age"],"type":"object"}`
+	if string(b) != want {
+		t.Errorf("got %s\nwant %s", b, want)
+	}
+
+	// output compiles to equivalent schema
+	if err := c.AddResource("schema2.json", bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	sch2, err := c.Compile("schema2.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []string{`{"age": 1}`, `{"age": -1}`, `{"age": 151}`, `{"age": 1, "x": 1}`, `{"age": 1, "tags": ["a", "a"]}`} {
+		v := decodeString(t, doc)
+		if (sch.Validate(v) == nil) != (sch2.Validate(v) == nil) {
+			t.Errorf("%s: schemas disagree", doc)
+		}
+	}
+
+	// draft specific keywords survive recompilation
+	tests := []struct {
+		schema  string
+		want    string
+		valid   []string
+		invalid []string
+	}{
+		{
+			`{"$schema": "http://json-schema.org/draft-04/schema#", "minimum": 1, "exclusiveMinimum": true}`,
+			`{"$schema":"https://json-schema.org/draft-04/schema","exclusiveMinimum":true,"minimum":1}`,
+			[]string{`2`}, []string{`1`},
+		},
+		{
+			`{"$schema": "http://json-schema.org/draft-07/schema#", "items": [{"type": "string"}], "additionalItems": false}`,
+			`{"$schema":"https://json-schema.org/draft-07/schema","additionalItems":false,"items":[{"type":"string"}]}`,
+			[]string{`["a"]`}, []string{`["a", 1]`, `[1]`},
+		},
+	}
+	for i, test := range tests {
+		sch := jsonschema.MustCompileString(fmt.Sprintf("draft%d.json", i), test.schema)
+		b, err := json.Marshal(sch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != test.want {
+			t.Errorf("got %s\nwant %s", b, test.want)
+			continue
+		}
+		sch2, err := jsonschema.CompileString(fmt.Sprintf("out%d.json", i), string(b))
+		if err != nil {
+			t.Errorf("%s: %v", b, err)
+			continue
+		}
+		for _, doc := range test.valid {
+			if err := sch2.Validate(decodeString(t, doc)); err != nil {
+				t.Errorf("%s: %s must be valid: %v", b, doc, err)
+			}
+		}
+		for _, doc := range test.invalid {
+			if err := sch2.Validate(decodeString(t, doc)); err == nil {
+				t.Errorf("%s: %s must be invalid", b, doc)
+			}
+		}
+	}
+
+	// $dynamicRef and $recursiveRef are still resolved dynamically
+	trees := []struct {
+		tree   string
+		strict string // extends tree at %s, by disallowing unknown properties
+		ref    string // marshalled ref in tree
+	}{
+		{
+			`{"$dynamicAnchor": "node", "properties": {"children": {"items": {"$dynamicRef": "#node"}}}}`,
+			`{"$dynamicAnchor": "node", "$ref": %q, "unevaluatedProperties": false}`,
+			`"$dynamicRef":"http://ex.com/tree0.json#node"`,
+		},
+		{
+			`{"$schema": "https://json-schema.org/draft/2019-09/schema", "$recursiveAnchor": true, "properties": {"children": {"items": {"$recursiveRef": "#"}}}}`,
+			`{"$schema": "https://json-schema.org/draft/2019-09/schema", "$recursiveAnchor": true, "$ref": %q, "unevaluatedProperties": false}`,
+			`"$recursiveRef":"#"`,
+		},
+	}
+	for i, test := range trees {
+		c := jsonschema.NewCompiler()
+		treeURL, outURL := fmt.Sprintf("http://ex.com/tree%d.json", i), fmt.Sprintf("http://ex.com/out%d.json", i)
+		if err := c.AddResource(treeURL, strings.NewReader(test.tree)); err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(c.MustCompile(treeURL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), test.ref) {
+			t.Errorf("%d: got %s, want %s", i, b, test.ref)
+		}
+		if err := c.AddResource(outURL, bytes.NewReader(b)); err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range []string{treeURL, outURL} {
+			if err := c.AddResource(strings.Replace(u, "ex.com/", "ex.com/strict-", 1), strings.NewReader(fmt.Sprintf(test.strict, u))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		strict := c.MustCompile(strings.Replace(treeURL, "ex.com/", "ex.com/strict-", 1))
+		strict2, err := c.Compile(strings.Replace(outURL, "ex.com/", "ex.com/strict-", 1))
+		if err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+		for _, doc := range []string{`{"children": [{"children": []}]}`, `{"children": [{"x": 1}]}`, `{"x": 1}`} {
+			v := decodeString(t, doc)
+			if (strict.Validate(v) == nil) != (strict2.Validate(v) == nil) {
+				t.Errorf("%s: %s: schemas disagree", b, doc)
+			}
+		}
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	// then/else without if are ignored
+	sch := jsonschema.MustCompileString("schema.json", `{"then": false, "else": false}`)
+	if sch.Then != nil || sch.Else != nil {
+		t.Error("then/else must be nil without if")
+	}
+	if err := sch.Validate(decodeString(t, `1`)); err != nil {
+		t.Error(err)
+	}
+
+	// each "if" must be evaluated once, however deep the chain is
+	const depth = 30
+	elseChain, ifChain := `false`, `{"format": "counted"}`
+	for i := depth - 1; i >= 0; i-- {
+		elseChain = fmt.Sprintf(`{"if": {"format": "counted", "const": %d}, "then": true, "else": %s}`, i, elseChain)
+		if i > 0 {
+			ifChain = fmt.Sprintf(`{"format": "counted", "if": %s, "then": true, "else": false}`, ifChain)
+		}
+	}
+	ifChain = `{"if": ` + ifChain + `, "then": {"const": 1}}`
+	tests := []struct {
+		name     string
+		schema   string
+		instance string
+		valid    bool
+	}{
+		{"else-chain last", elseChain, fmt.Sprint(depth - 1), true},
+		{"else-chain none", elseChain, fmt.Sprint(depth), false},
+		{"if-chain", ifChain, `1`, true},
+		{"if-chain", ifChain, `2`, false},
+	}
+	for _, test := range tests {
+		calls := 0
+		c := jsonschema.NewCompiler()
+		c.AssertFormat = true
+		c.Formats["counted"] = func(interface{}) bool {
+			calls++
+			return true
+		}
+		if err := c.AddResource("schema.json", strings.NewReader(test.schema)); err != nil {
+			t.Fatal(err)
+		}
+		sch := c.MustCompile("schema.json")
+		err := sch.Validate(decodeString(t, test.instance))
+		if test.valid != (err == nil) {
+			t.Errorf("%s: %s: valid %t, got %v", test.name, test.instance, test.valid, err)
+		}
+		if calls != depth {
+			t.Errorf("%s: %s: if evaluated %d times, want %d", test.name, test.instance, calls, depth)
+		}
+	}
+}
+
+func TestResources(t *testing.T) {
+	docs := map[string]string{
+		"http://example.com/root.json": `{
+			"properties": {
+				"a": {"$ref": "a.json"},
+				"b": {"$ref": "a.json#/$defs/x"},
+				"c": {"$ref": "c.json"}
+			}
+		}`,
+		"http://example.com/a.json": `{"$defs": {"x": {"$ref": "meta-user.json"}}, "$ref": "c.json"}`,
+		"http://example.com/c.json": `{"items": {"$ref": "root.json"}}`,
+		"http://example.com/meta-user.json": `{
+			"$schema": "http://example.com/meta.json",
+			"type": "string"
+		}`,
+		"http://example.com/meta.json": `{
+			"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"$ref": "https://json-schema.org/draft/2020-12/schema"
+		}`,
+	}
+	c := jsonschema.NewCompiler()
+	c.AllowRemoteRefs = true
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		doc, ok := docs[s]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", s)
+		}
+		return io.NopCloser(strings.NewReader(doc)), nil
+	}
+	sch, err := c.Compile("http://example.com/root.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := sch.Resources()
+	want := []string{
+		"http://example.com/root.json",
+		"http://example.com/a.json",
+		"http://example.com/c.json",
+		"http://example.com/meta-user.json",
+		"http://example.com/meta.json",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFileRefWithSpaces(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "my schemas", "ünïcödé")
+	if err := os.MkdirAll(filepath.Join(dir, "sub dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"main schema.json": `{
+			"properties": {
+				"a": {"$ref": "sub dir/other file.json"},
+				"b": {"$ref": "sub%20dir/other%20file.json"},
+				"c": {"$ref": "größe.json"}
+			}
+		}`,
+		"sub dir/other file.json": `{"$ref": "../größe.json"}`,
+		"größe.json":              `{"type": "string", "items": {"$ref": "main schema.json"}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	main := filepath.Join(dir, "main schema.json")
+	for _, loc := range []string{main, toFileURL(main)} {
+		sch, err := jsonschema.Compile(loc)
+		if err != nil {
+			t.Fatalf("%s: %v", loc, err)
+		}
+		size := sch.Properties["c"].Ref
+		if sch.Properties["a"].Ref != sch.Properties["b"].Ref || sch.Properties["a"].Ref.Ref != size {
+			t.Errorf("%s: escaped and unescaped refs must resolve to same schema", loc)
+		}
+		if size.Items2020.Ref != sch {
+			t.Errorf("%s: ref back to main schema must resolve to root", loc)
+		}
+		if err := sch.Validate(decodeString(t, `{"a": "x", "b": 1}`)); err == nil {
+			t.Errorf("%s: want error", loc)
+		}
+	}
+}
+
+func TestFormatsWithDetail(t *testing.T) {
+	luhn := func(v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return nil
+		}
+		sum := 0
+		for i := range s {
+			d := int(s[len(s)-1-i] - '0')
+			if d < 0 || d > 9 {
+				return fmt.Errorf("invalid digit %q", s[len(s)-1-i])
+			}
+			if i%2 == 1 {
+				if d *= 2; d > 9 {
+					d -= 9
+				}
+			}
+			sum += d
+		}
+		if sum%10 != 0 {
+			return errors.New("checksum mismatch")
+		}
+		return nil
+	}
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	c.FormatsWithDetail["card"] = luhn
+	// takes precedence over Formats
+	c.Formats["card"] = func(interface{}) bool { return true }
+	if err := c.AddResource("schema.json", strings.NewReader(`{"format": "card"}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch := c.MustCompile("schema.json")
+
+	tests := []struct {
+		instance string
+		want     string
+	}{
+		{`"4539578763621486"`, ""},
+		{`"4539578763621487"`, `'4539578763621487' is not valid 'card': checksum mismatch`},
+		{`"45x9"`, `'45x9' is not valid 'card': invalid digit 'x'`},
+	}
+	for _, test := range tests {
+		err := sch.Validate(decodeString(t, test.instance))
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.instance, err)
+			}
+			continue
+		}
+		ve, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			t.Fatalf("%s: want ValidationError, got %v", test.instance, err)
+		}
+		leaf := ve.Causes[0]
+		if m, ok := leaf.Message.(msg.Format); !ok || m.String() != test.want {
+			t.Errorf("%s: got %#v, want %q", test.instance, leaf.Message, test.want)
+		}
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	sch := jsonschema.MustCompile("testdata/person_schema.json")
+	if err := sch.ValidateFile("testdata/person.json"); err != nil {
+		t.Error(err)
+	}
+	if err := sch.ValidateFile("testdata/customer.json"); err == nil {
+		t.Error("want error")
+	} else if _, ok := err.(*jsonschema.ValidationError); !ok {
+		t.Errorf("want ValidationError, got %#v", err)
+	}
+	if err := sch.ValidateFile("testdata/syntax_error.json"); err == nil {
+		t.Error("want error")
+	}
+	if err := sch.ValidateFile("testdata/missing.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want ErrNotExist, got %v", err)
+	}
+}
+
+func TestAllowComments(t *testing.T) {
+	schema := `// person schema
+	{
+		/* names are
+		   required */
+		"type": "object", // must be object
+		"properties": {
+			"name": {"type": "string", "pattern": "^[^/]*//?$"},
+			"note": {"const": "/* not a comment */ \" // still string"},
+		},
+		"required": ["name",],
+	}`
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("schema.json", strings.NewReader(schema)); err == nil {
+		t.Fatal("comments must be rejected by default")
+	}
+
+	c = jsonschema.NewCompiler()
+	c.AllowComments = true
+	c.PreserveOrder = true
+	if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+		t.Fatal(err)
+	}
+	sch := c.MustCompile("schema.json")
+	if got := strings.Join(sch.PropertyOrder, ","); got != "name,note" {
+		t.Errorf("PropertyOrder: got %s", got)
+	}
+	if err := sch.Validate(decodeString(t, `{"name": "a//", "note": "/* not a comment */ \" // still string"}`)); err != nil {
+		t.Error(err)
+	}
+	if err := sch.Validate(decodeString(t, `{"note": "x"}`)); err == nil {
+		t.Error("want error")
+	}
+
+	// instances stay strict json
+	if err := sch.ValidateBytes([]byte(`{"name": "a" /* comment */}`)); err == nil {
+		t.Error("comments in instance must be rejected")
+	}
+
+	c = jsonschema.NewCompiler()
+	c.AllowComments = true
+	if err := c.AddResource("schema.json", strings.NewReader(`{"type": "string" /* unterminated`)); err == nil {
+		t.Error("unterminated comment must be rejected")
+	}
+}
+
+func TestLint(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"minItems": 1,
+		"properties": {
+			"age": {"type": "string", "minimum": 0, "maxLength": 3},
+			"count": {"type": "integer", "minimum": 0},
+			"tags": {"type": ["array", "null"], "pattern": "^a", "items": {"pattern": "^a"}}
+		}
+	}`
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+		t.Fatal(err)
+	}
+	c.MustCompile("schema.json")
+	if len(c.LintWarnings) != 0 {
+		t.Errorf("lint must be off by default, got %v", c.LintWarnings)
+	}
+
+	c = jsonschema.NewCompiler()
+	c.Lint = true
+	if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+		t.Fatal(err)
+	}
+	c.MustCompile("schema.json")
+	got := map[string]string{}
+	for _, w := range c.LintWarnings {
+		loc := w.Location[strings.IndexByte(w.Location, '#'):]
+		got[loc] += w.Keyword + " "
+	}
+	want := map[string]string{
+		"#":                 "minItems ",
+		"#/properties/age":  "minimum ",
+		"#/properties/tags": "pattern ",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(c.LintWarnings) > 0 {
+		if w := c.LintWarnings[0].String(); !strings.Contains(w, `"minItems" has no effect`) {
+			t.Errorf("String: got %q", w)
+		}
+	}
+
+	// warnings are reset by each Compile, and schemas are linted once
+	c.MustCompile("schema.json#/properties/age")
+	if len(c.LintWarnings) != 0 {
+		t.Errorf("already linted: got %v", c.LintWarnings)
+	}
+
+	// keywords are those of the schema's draft
+	tests := []struct {
+		schema string
+		want   string
+	}{
+		{`{"$schema": "http://json-schema.org/draft-04/schema#", "type": "string", "minimum": 1, "exclusiveMinimum": true}`, "exclusiveMinimum minimum "},
+		{`{"$schema": "http://json-schema.org/draft-04/schema#", "type": "number", "minimum": 1, "exclusiveMinimum": true}`, ""},
+		{`{"$schema": "http://json-schema.org/draft-04/schema#", "type": "string", "contains": true}`, ""},
+		{`{"$schema": "http://json-schema.org/draft-07/schema#", "type": "string", "contains": true, "prefixItems": []}`, "contains "},
+		{`{"type": "string", "prefixItems": [true], "additionalItems": true}`, "prefixItems "},
+	}
+	for i, test := range tests {
+		url := fmt.Sprintf("draft%d.json", i)
+		if err := c.AddResource(url, strings.NewReader(test.schema)); err != nil {
+			t.Fatal(err)
+		}
+		c.MustCompile(url)
+		got := ""
+		for _, w := range c.LintWarnings {
+			got += w.Keyword + " "
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.schema, got, test.want)
+		}
+	}
+}
+
+func TestDraftMetaschemaOffline(t *testing.T) {
+	offline := func() *jsonschema.Compiler {
+		c := jsonschema.NewCompiler()
+		c.LoadURL = func(s string) (io.ReadCloser, error) {
+			return nil, fmt.Errorf("no network: %s", s)
+		}
+		return c
+	}
+	tests := []struct {
+		ref     string
+		valid   string
+		invalid string
+	}{
+		{"http://json-schema.org/draft-07/schema#", `{"minLength": 1}`, `{"minLength": -1}`},
+		{"http://json-schema.org/draft-07/schema#/definitions/nonNegativeInteger", `1`, `-1`},
+		{"https://json-schema.org/draft-07/schema#/definitions/nonNegativeInteger", `1`, `-1`},
+		{"http://json-schema.org/draft-04/schema#/definitions/positiveInteger", `0`, `-1`},
+		{"https://json-schema.org/draft/2020-12/schema#/$defs/unused", `1`, ``},
+		{"https://json-schema.org/draft/2020-12/meta/validation#/$defs/nonNegativeInteger", `1`, `-1`},
+	}
+	for _, test := range tests {
+		c := offline()
+		if err := c.AddResource("schema.json", strings.NewReader(`{"$ref": "`+test.ref+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+		sch, err := c.Compile("schema.json")
+		if err != nil {
+			if test.invalid != "" {
+				t.Errorf("%s: %v", test.ref, err)
+			}
+			continue
+		}
+		if test.invalid == "" {
+			t.Errorf("%s: want error", test.ref)
+			continue
+		}
+		if err := sch.Validate(decodeString(t, test.valid)); err != nil {
+			t.Errorf("%s: %s: %v", test.ref, test.valid, err)
+		}
+		if err := sch.Validate(decodeString(t, test.invalid)); err == nil {
+			t.Errorf("%s: %s: want error", test.ref, test.invalid)
+		}
+	}
+
+	// explicitly added resource overrides embedded metaschema
+	c := offline()
+	if err := c.AddResource("http://json-schema.org/draft-07/schema", strings.NewReader(`{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"definitions": {"nonNegativeInteger": {"type": "integer", "minimum": 10}},
+		"type": "object"
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range []struct {
+		ref      string
+		instance string
+	}{
+		{"http://json-schema.org/draft-07/schema#", `1`},
+		{"http://json-schema.org/draft-07/schema#/definitions/nonNegativeInteger", `5`},
+	} {
+		url := fmt.Sprintf("user%d.json", i)
+		if err := c.AddResource(url, strings.NewReader(`{"$ref": "`+test.ref+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+		sch, err := c.Compile(url)
+		if err != nil {
+			t.Fatalf("%s: %v", test.ref, err)
+		}
+		if err := sch.Validate(decodeString(t, test.instance)); err == nil {
+			t.Errorf("%s: %s: want error from overridden metaschema", test.ref, test.instance)
+		}
+	}
+}
+
+func TestValidateN(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"type": "array",
+		"items": {"type": "object", "properties": {"a": {"type": "string"}, "b": {"type": "string"}}}
+	}`)
+	doc := `[{"a": 1, "b": 1}, {"a": 1, "b": 1}, {"a": 1, "b": 1}]`
+
+	err := sch.ValidateN(strings.NewReader(doc), 0)
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		t.Fatalf("want ValidationError, got %v", err)
+	}
+	if got := len(ve.LeafErrors()); got != 6 {
+		t.Errorf("no limit: got %d leaf errors, want 6", got)
+	}
+
+	for _, max := range []int{1, 3, 5} {
+		err := sch.ValidateN(strings.NewReader(doc), max)
+		ve, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			t.Fatalf("%d: want ValidationError, got %v", max, err)
+		}
+		leaves := ve.LeafErrors()
+		if len(leaves) != max {
+			t.Errorf("%d: got %d leaf errors, want %d", max, len(leaves), max)
+			continue
+		}
+		if !ve.Truncated {
+			t.Errorf("%d: Truncated must be set", max)
+		}
+		for _, leaf := range leaves {
+			if _, ok := leaf.Message.(msg.Type); !ok {
+				t.Errorf("%d: got %#v, want type error", max, leaf.Message)
+			}
+		}
+	}
+
+	// remaining items are not validated, once limit is reached
+	counted := 0
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	c.Formats["counted"] = func(interface{}) bool {
+		counted++
+		return false
+	}
+	if err := c.AddResource("counted.json", strings.NewReader(`{"items": {"format": "counted"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch2 := c.MustCompile("counted.json")
+	err = sch2.ValidateN(strings.NewReader(`[1, 2, 3, 4, 5, 6, 7, 8, 9, 10]`), 2)
+	if ve, ok := err.(*jsonschema.ValidationError); !ok || len(ve.LeafErrors()) != 2 || !ve.Truncated {
+		t.Errorf("got %#v, want 2 leaf errors truncated", err)
+	}
+	if counted != 2 {
+		t.Errorf("format checked %d times, want 2", counted)
+	}
+
+	// anyOf is applied fully, since its outcome decides validity
+	sch2 = jsonschema.MustCompileString("anyOf.json", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`)
+	err = sch2.ValidateN(strings.NewReader(`1.5`), 1)
+	if ve, ok := err.(*jsonschema.ValidationError); !ok || len(ve.LeafErrors()) != 1 || !ve.Truncated {
+		t.Errorf("got %#v, want 1 leaf error truncated", err)
+	}
+	if err := sch2.ValidateN(strings.NewReader(`1`), 1); err != nil {
+		t.Error(err)
+	}
+
+	if ve, ok := sch.ValidateN(strings.NewReader(doc), 6).(*jsonschema.ValidationError); !ok || ve.Truncated {
+		t.Errorf("6: got %v, want not truncated", ve)
+	}
+
+	if err := sch.ValidateN(strings.NewReader(`[{"a": "x"}]`), 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGoNumbers(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"type": "object",
+		"properties": {
+			"count": {"type": "integer", "minimum": 1},
+			"ratio": {"type": "number", "maximum": 1},
+			"code": {"enum": [7, 8]},
+			"ids": {"type": "array", "uniqueItems": true}
+		}
+	}`)
+	tests := []struct {
+		doc   map[string]interface{}
+		valid bool
+	}{
+		{map[string]interface{}{"count": 3, "ratio": 0.5, "code": int16(7), "ids": []interface{}{uint16(1), int64(2)}}, true},
+		{map[string]interface{}{"count": int8(0)}, false},
+		{map[string]interface{}{"count": 1.5}, false},
+		{map[string]interface{}{"ratio": float32(1.5)}, false},
+		{map[string]interface{}{"code": uint8(9)}, false},
+		{map[string]interface{}{"ids": []interface{}{1, json.Number("1"), 1.0}}, false},
+		{map[string]interface{}{"ids": []interface{}{uint(1), int32(2)}}, true},
+	}
+	for i, test := range tests {
+		err := sch.Validate(test.doc)
+		if test.valid != (err == nil) {
+			t.Errorf("#%d: valid %t, got %v", i, test.valid, err)
+		}
+		if _, ok := err.(jsonschema.InvalidJSONTypeError); ok {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+
+	for _, doc := range []map[string]interface{}{
+		{"ratio": math.NaN()},
+		{"ratio": math.Inf(1)},
+		{"ids": []interface{}{math.NaN(), 1}},
+	} {
+		if _, ok := sch.Validate(doc).(jsonschema.InvalidJSONTypeError); !ok {
+			t.Errorf("%v: want InvalidJSONTypeError", doc)
+		}
+	}
+	if _, ok := sch.Validate(map[string]int{"count": 1}).(jsonschema.InvalidJSONTypeError); !ok {
+		t.Error("map[string]int: want InvalidJSONTypeError")
+	}
+
+	// rejected even where no keyword looks at the value
+	for _, schema := range []string{`{}`, `{"type": "number"}`} {
+		sch := jsonschema.MustCompileString("nonfinite.json", schema)
+		for _, doc := range []interface{}{
+			math.NaN(),
+			math.Inf(1),
+			math.Inf(-1),
+			float32(math.Inf(-1)),
+			map[string]interface{}{"x": math.NaN()},
+			[]interface{}{1, math.Inf(1)},
+		} {
+			err := sch.Validate(doc)
+			if _, ok := err.(jsonschema.InvalidJSONTypeError); !ok {
+				t.Errorf("%s: %v: got %v, want InvalidJSONTypeError", schema, doc, err)
+			}
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"properties": {
+			"pet": {
+				"oneOf": [{"$ref": "#/$defs/cat"}, {"$ref": "#/$defs/dog"}],
+				"discriminator": {"propertyName": "kind"}
+			}
+		},
+		"$defs": {
+			"cat": {"properties": {"kind": {"const": "cat"}}, "required": ["kind"], "example": {"kind": "cat"}},
+			"dog": {"properties": {"kind": {"const": "dog"}}, "required": ["kind"], "xml": {"name": "dog"}}
+		},
+		"externalDocs": {"url": "https://example.com/pets"}
+	}`
+	compile := func(openAPI bool, schema string) (*jsonschema.Schema, error) {
+		c := jsonschema.NewCompiler()
+		c.Draft = jsonschema.Draft7
+		c.Strict = true
+		c.ExtractAnnotations = true
+		c.OpenAPI = openAPI
+		c.LoadURL = func(s string) (io.ReadCloser, error) {
+			return nil, fmt.Errorf("no network: %s", s)
+		}
+		if err := c.AddResource("openapi.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		return c.Compile("openapi.json")
+	}
+	if _, err := compile(false, schema); err == nil {
+		t.Error("strict: OpenAPI keywords must be rejected without OpenAPI")
+	}
+
+	dialect := `{"$schema": "https://spec.openapis.org/oas/3.1/dialect/base",` + strings.TrimPrefix(schema, "{")
+	for _, test := range []struct {
+		name    string
+		openAPI bool
+		schema  string
+	}{
+		{"option", true, schema},
+		{"dialect", false, dialect},
+	} {
+		sch, err := compile(test.openAPI, test.schema)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if sch.Draft != jsonschema.Draft2020 {
+			t.Errorf("%s: got %v, want Draft2020", test.name, sch.Draft)
+		}
+		cat := sch.Properties["pet"].OneOf[0].Ref
+		if len(cat.Examples) != 1 {
+			t.Errorf("%s: example must be collected into Examples, got %v", test.name, cat.Examples)
+		}
+		if err := sch.Validate(decodeString(t, `{"pet": {"kind": "dog"}}`)); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if err := sch.Validate(decodeString(t, `{"pet": {"kind": "cow"}}`)); err == nil {
+			t.Errorf("%s: want error", test.name)
+		}
+	}
+}
+
+func TestTreatNullableKeyword(t *testing.T) {
+	schema := `{
+		"properties": {
+			"name": {"type": "string", "nullable": true},
+			"tags": {"type": ["array", "null"], "nullable": true},
+			"age": {"type": "integer", "nullable": false},
+			"any": {"nullable": true}
+		}
+	}`
+	compile := func(nullable bool) *jsonschema.Schema {
+		c := jsonschema.NewCompiler()
+		c.TreatNullableKeyword = nullable
+		if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		return c.MustCompile("schema.json")
+	}
+	tests := []struct {
+		nullable bool
+		instance string
+		valid    bool
+	}{
+		{false, `{"name": null}`, false},
+		{true, `{"name": null}`, true},
+		{true, `{"name": "x"}`, true},
+		{true, `{"name": 1}`, false},
+		{true, `{"tags": null}`, true},
+		{true, `{"age": null}`, false},
+		{true, `{"any": 1}`, true},
+	}
+	for i, test := range tests {
+		err := compile(test.nullable).Validate(decodeString(t, test.instance))
+		if test.valid != (err == nil) {
+			t.Errorf("#%d: nullable %t: %s: valid %t, got %v", i, test.nullable, test.instance, test.valid, err)
+		}
+	}
+	if got := compile(true).Properties["tags"].Types; len(got) != 2 {
+		t.Errorf("null must not be added twice: %v", got)
+	}
+
+	c := jsonschema.NewCompiler()
+	c.Strict = true
+	c.TreatNullableKeyword = true
+	if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Compile("schema.json"); err != nil {
+		t.Errorf("strict: %v", err)
+	}
+}
+
+func TestSchemaID(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("http://example.com/root.json", strings.NewReader(`{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"properties": {
+			"name": {"type": "string"},
+			"address": {"$id": "address.json", "properties": {"street": {"type": "string"}}}
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch := c.MustCompile("http://example.com/root.json")
+	tests := []struct {
+		sch  *jsonschema.Schema
+		want string
+	}{
+		{sch, "http://example.com/root.json"},
+		{sch.Properties["name"], "http://example.com/root.json"},
+		{sch.Properties["address"], "http://example.com/address.json"},
+		{sch.Properties["address"].Properties["street"], "http://example.com/address.json"},
+	}
+	for _, test := range tests {
+		if got := test.sch.ID(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.sch.Location, got, test.want)
+		}
+		if test.sch.Draft != jsonschema.Draft7 {
+			t.Errorf("%s: got %v, want Draft7", test.sch.Location, test.sch.Draft)
+		}
+	}
+
+	for schema, want := range map[string]string{
+		`{"type": "string"}`:                        "inline:///schema.json",
+		`{"$id": "http://example.com/inline.json"}`: "http://example.com/inline.json",
+	} {
+		sch, err := jsonschema.CompileReader(strings.NewReader(schema))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sch.ID(); got != want {
+			t.Errorf("%s: got %s, want %s", schema, got, want)
+		}
+	}
+}
+
+func TestContentSchema(t *testing.T) {
+	schema := `{
+		"$schema": "https://json-schema.org/draft/2019-09/schema",
+		"properties": {
+			"data": {
+				"contentEncoding": "base64",
+				"contentMediaType": "application/json",
+				"contentSchema": {"required": ["id"], "properties": {"id": {"type": "integer"}}}
+			}
+		}
+	}`
+	compile := func(assert bool) *jsonschema.Schema {
+		c := jsonschema.NewCompiler()
+		c.AssertContent = assert
+		if err := c.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+			t.Fatal(err)
+		}
+		return c.MustCompile("schema.json")
+	}
+	enc := func(s string) string {
+		return `{"data": "` + base64.StdEncoding.EncodeToString([]byte(s)) + `"}`
+	}
+	tests := []struct {
+		assert   bool
+		instance string
+		keyword  string // keyword location of leaf error. empty if valid
+	}{
+		{true, enc(`{"id": 1}`), ""},
+		{true, enc(`{"id": "1"}`), "/properties/data/contentSchema/properties/id/type"},
+		{true, enc(`{}`), "/properties/data/contentSchema/required"},
+		{true, enc(`{"id":`), "/properties/data/contentMediaType"},
+		{true, `{"data": "%%%"}`, "/properties/data/contentEncoding"},
+		// annotation only
+		{false, enc(`{"id": "1"}`), ""},
+		{false, `{"data": "%%%"}`, ""},
+	}
+	for i, test := range tests {
+		err := compile(test.assert).Validate(decodeString(t, test.instance))
+		if test.keyword == "" {
+			if err != nil {
+				t.Errorf("#%d: %v", i, err)
+			}
+			continue
+		}
+		ve, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			t.Errorf("#%d: want ValidationError, got %v", i, err)
+			continue
+		}
+		leaves := ve.LeafErrors()
+		if got := leaves[0].KeywordLocation; got != test.keyword {
+			t.Errorf("#%d: got %s, want %s", i, got, test.keyword)
+		}
+	}
+}
+
+func TestErrorOrder(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {
+			"e": {"type": "string"}, "d": {"type": "string"}, "c": {"type": "string"},
+			"b": {"type": "string"}, "a": {"type": "string"},
+			"list": {"items": {"type": "string"}}
+		},
+		"patternProperties": {"^[a-e]$": {"minLength": 2}, "^[c-e]$": {"maxLength": 0}},
+		"dependentRequired": {"a": ["x"], "b": ["y"], "c": ["z"]},
+		"unevaluatedProperties": false
+	}`)
+	doc := decodeString(t, `{"a": 1, "b": 1, "c": 1, "d": 1, "e": 1, "f": 1, "g": 1,
+		"list": [1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1]}`)
+	want := fmt.Sprintf("%#v", sch.Validate(doc))
+	for i := 0; i < 20; i++ {
+		if got := fmt.Sprintf("%#v", sch.Validate(doc)); got != want {
+			t.Fatalf("order differs between runs:\n%s\n%s", got, want)
+		}
+	}
+
+	ve := sch.Validate(doc).(*jsonschema.ValidationError)
+	var locs []string
+	for _, cause := range ve.Causes {
+		locs = append(locs, cause.InstanceLocation+" "+cause.KeywordLocation)
+	}
+	wantLocs := []string{
+		" /dependentRequired/a/0",
+		" /dependentRequired/b/0",
+		" /dependentRequired/c/0",
+		" /unevaluatedProperties",
+		"/a /properties/a/type",
+		"/b /properties/b/type",
+		"/c /properties/c/type",
+		"/d /properties/d/type",
+		"/e /properties/e/type",
+		"/list /properties/list",
+	}
+	if strings.Join(locs, "\n") != strings.Join(wantLocs, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(locs, "\n"), strings.Join(wantLocs, "\n"))
+	}
+	var items []string
+	for _, leaf := range ve.LeafErrors() {
+		if strings.HasPrefix(leaf.InstanceLocation, "/list/") {
+			items = append(items, strings.TrimPrefix(leaf.InstanceLocation, "/list/"))
+		}
+	}
+	if got := strings.Join(items, ","); got != "0,1,2,3,4,5,6,7,8,9,10,11" {
+		t.Errorf("items: got %s", got)
+	}
+}
+
+func TestValidateAndCoerce(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {
+			"age": {"type": "integer", "minimum": 0},
+			"price": {"$ref": "#/$defs/price"},
+			"active": {"type": "boolean"},
+			"note": {"type": "null"},
+			"id": {"type": ["integer", "string"]},
+			"name": {"type": "string"},
+			"tags": {"items": {"type": "integer"}},
+			"pair": {"prefixItems": [{"type": "boolean"}], "items": {"type": "number"}}
+		},
+		"patternProperties": {"^x-": {"allOf": [{"type": "number"}]}},
+		"additionalProperties": {"type": "boolean"},
+		"$defs": {"price": {"type": "number"}}
+	}`)
+	tests := []struct {
+		doc   string
+		want  string
+		valid bool
+	}{
+		{
+			`{"age": "42", "price": "9.5", "active": "true", "note": "null", "id": "7", "name": "10"}`,
+			`{"active":true,"age":42,"id":"7","name":"10","note":null,"price":9.5}`,
+			true,
+		},
+		{`{"tags": ["1", "2"], "pair": ["false", "1.5", "2"]}`, `{"pair":[false,1.5,2],"tags":[1,2]}`, true},
+		{`{"x-rate": "0.25", "other": "false"}`, `{"other":false,"x-rate":0.25}`, true},
+		{`{"age": "1.5"}`, `{"age":"1.5"}`, false},
+		{`{"age": "abc"}`, `{"age":"abc"}`, false},
+		{`{"age": "-1"}`, `{"age":-1}`, false},
+		{`{"active": "yes"}`, `{"active":"yes"}`, false},
+		// trailing garbage is not coerced
+		{`{"age": "12abc"}`, `{"age":"12abc"}`, false},
+		{`{"age": "12 abc"}`, `{"age":"12 abc"}`, false},
+		{`{"age": "1}"}`, `{"age":"1}"}`, false},
+		{`{"age": "12 "}`, `{"age":"12 "}`, false},
+		{`{"age": "12 13"}`, `{"age":"12 13"}`, false},
+	}
+	for _, test := range tests {
+		doc, err := sch.ValidateAndCoerce(decodeString(t, test.doc))
+		if test.valid != (err == nil) {
+			t.Errorf("%s: valid %t, got %v", test.doc, test.valid, err)
+		}
+		b, _ := json.Marshal(doc)
+		if string(b) != test.want {
+			t.Errorf("%s: got %s, want %s", test.doc, b, test.want)
+		}
+	}
+
+	// ambiguous types: "price" is number by $ref, but string by allOf
+	sch = jsonschema.MustCompileString("ambiguous.json", `{
+		"properties": {"price": {"$ref": "#/$defs/price", "allOf": [{"type": "string"}]}},
+		"$defs": {"price": {"type": "number"}}
+	}`)
+	doc, _ := sch.ValidateAndCoerce(decodeString(t, `{"price": "1"}`))
+	if b, _ := json.Marshal(doc); string(b) != `{"price":"1"}` {
+		t.Errorf("ambiguous: got %s", b)
+	}
+}
+
+func TestIntegerType(t *testing.T) {
+	tests := []struct {
+		num   string
+		valid bool
+	}{
+		{"1", true},
+		{"1.0", true},
+		{"1.00", true},
+		{"-0.0", true},
+		{"1e2", true},
+		{"1E+2", true},
+		{"1.5e1", true},
+		{"12345678901234567890.0", true},
+		{"1.5", false},
+		{"1e-2", false},
+		{"0.1e1", true},
+	}
+	drafts := []*jsonschema.Draft{jsonschema.Draft4, jsonschema.Draft6, jsonschema.Draft7, jsonschema.Draft2019, jsonschema.Draft2020}
+	for _, draft := range drafts {
+		c := jsonschema.NewCompiler()
+		c.Draft = draft
+		if err := c.AddResource("schema.json", strings.NewReader(`{"type": "integer"}`)); err != nil {
+			t.Fatal(err)
+		}
+		sch := c.MustCompile("schema.json")
+		for _, test := range tests {
+			for _, v := range []interface{}{json.Number(test.num), decodeString(t, test.num)} {
+				if err := sch.Validate(v); test.valid != (err == nil) {
+					t.Errorf("%v: %T %s: valid %t, got %v", draft, v, test.num, test.valid, err)
+				}
+			}
+			if f, err := strconv.ParseFloat(test.num, 64); err == nil {
+				if err := sch.Validate(f); test.valid != (err == nil) {
+					t.Errorf("%v: float64 %s: valid %t, got %v", draft, test.num, test.valid, err)
+				}
+			}
+		}
+	}
+}
+
+func TestLazyRefs(t *testing.T) {
+	var loaded []string
+	c := jsonschema.NewCompiler()
+	c.LazyRefs = true
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		loaded = append(loaded, s)
+		return nil, fmt.Errorf("%s not available", s)
+	}
+	if err := c.AddResource("http://example.com/name.json", strings.NewReader(`{"type": "string"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddResource("http://example.com/schema.json", strings.NewReader(`{
+		"properties": {
+			"name": {"$ref": "name.json"},
+			"age": {"$ref": "#/$defs/age"},
+			"address": {"$ref": "address.json#/$defs/address"}
+		},
+		"$defs": {
+			"age": {"type": "integer"}
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := c.Compile("http://example.com/schema.json")
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if len(loaded) > 0 {
+		t.Fatalf("loaded %v", loaded)
+	}
+	address := sch.Properties["address"].Ref
+	if address.Always == nil || !*address.Always {
+		t.Fatal("skipped ref must be always valid")
+	}
+	if got, want := address.Location, "http://example.com/address.json#/$defs/address"; got != want {
+		t.Errorf("location: got %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`{"name": "john", "age": 20, "address": 1}`, true},
+		{`{"name": 1}`, false},
+		{`{"age": "20"}`, false},
+	}
+	for _, test := range tests {
+		if err := sch.Validate(decodeString(t, test.doc)); test.valid != (err == nil) {
+			t.Errorf("%s: valid %t, got %v", test.doc, test.valid, err)
+		}
+	}
+
+	t.Run("missingFragment", func(t *testing.T) {
+		c := jsonschema.NewCompiler()
+		c.LazyRefs = true
+		if err := c.AddResource("schema.json", strings.NewReader(`{"$ref": "#/$defs/missing"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Compile("schema.json"); err == nil {
+			t.Fatal("error expected")
+		}
+	})
+}
+
+func TestPropertyNames(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	if err := c.AddResource("schema.json", strings.NewReader(`{
+		"propertyNames": {
+			"pattern": "^[a-z]+$",
+			"format": "hostname",
+			"maxLength": 5,
+			"minimum": 10,
+			"multipleOf": 3
+		}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch := c.MustCompile("schema.json")
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`{}`, true},
+		{`{"abc": 1, "xyz": 2}`, true},
+		{`{"1": 1}`, false},      // pattern, even if numeric keywords are satisfied
+		{`{"Abc": 1}`, false},    // pattern
+		{`{"abcdef": 1}`, false}, // maxLength
+		{`[1, 2]`, true},         // not an object
+	}
+	for _, test := range tests {
+		if err := sch.Validate(decodeString(t, test.doc)); test.valid != (err == nil) {
+			t.Errorf("%s: valid %t, got %v", test.doc, test.valid, err)
+		}
+	}
+
+	// format is applied to each key
+	c = jsonschema.NewCompiler()
+	c.AssertFormat = true
+	if err := c.AddResource("schema.json", strings.NewReader(`{"propertyNames": {"format": "ipv4"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	sch = c.MustCompile("schema.json")
+	if err := sch.Validate(decodeString(t, `{"127.0.0.1": true}`)); err != nil {
+		t.Errorf("valid key: %v", err)
+	}
+	err := sch.Validate(decodeString(t, `{"localhost": true}`))
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		t.Fatalf("got %#v, want *ValidationError", err)
+	}
+	ve = leafError(ve)
+	if got, want := ve.KeywordLocation, "/propertyNames/format"; got != want {
+		t.Errorf("keywordLocation: got %q, want %q", got, want)
+	}
+	if got, want := ve.InstanceLocation, "/localhost"; got != want {
+		t.Errorf("instanceLocation: got %q, want %q", got, want)
+	}
+}
+
+func TestCachedValidator(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	c.Formats["counted"] = func(v interface{}) bool {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		s, ok := v.(string)
+		return !ok || s != "bad"
+	}
+	if err := c.AddResource("schema.json", strings.NewReader(`{"format": "counted"}`)); err != nil {
+		t.Fatal(err)
+	}
+	cv := c.MustCompile("schema.json").CachedValidator(2)
+
+	validate := func(doc string, valid bool, wantCalls int) {
+		t.Helper()
+		if err := cv.ValidateBytes([]byte(doc)); valid != (err == nil) {
+			t.Errorf("%s: valid %t, got %v", doc, valid, err)
+		}
+		if calls != wantCalls {
+			t.Errorf("%s: got %d calls, want %d", doc, calls, wantCalls)
+		}
+	}
+	validate(`"good"`, true, 1)
+	validate(`"good"`, true, 1)
+	validate(`"bad"`, false, 2)
+	validate(`"bad"`, false, 2)
+	validate(` "good"`, true, 3) // different bytes
+	if got := cv.Len(); got != 2 {
+		t.Errorf("len: got %d, want 2", got)
+	}
+	validate(`"good"`, true, 4) // evicted as least recently used
+	validate(`"bad"`, false, 5)
+	if err := cv.ValidateBytes([]byte(`{`)); err == nil {
+		t.Error("invalid json must fail")
+	}
+
+	t.Run("noCache", func(t *testing.T) {
+		cv := c.MustCompile("schema.json").CachedValidator(0)
+		before := calls
+		for i := 0; i < 3; i++ {
+			if err := cv.ValidateBytes([]byte(`"good"`)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if calls-before != 3 || cv.Len() != 0 {
+			t.Errorf("got %d calls and len %d", calls-before, cv.Len())
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		cv := c.MustCompile("schema.json").CachedValidator(8)
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					doc := fmt.Sprintf(`"doc%d"`, (i+j)%12)
+					if err := cv.ValidateBytes([]byte(doc)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		if got := cv.Len(); got != 8 {
+			t.Errorf("len: got %d, want 8", got)
+		}
+	})
+}
+
+func BenchmarkCachedValidator(b *testing.B) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"type": "array",
+		"items": {
+			"type": "object",
+			"required": ["id", "name"],
+			"properties": {
+				"id": {"type": "integer"},
+				"name": {"type": "string", "minLength": 1},
+				"tags": {"type": "array", "items": {"type": "string"}}
+			}
+		}
+	}`)
+	var docs [][]byte
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		buf.WriteString("[")
+		for j := 0; j < 100; j++ {
+			if j > 0 {
+				buf.WriteString(",")
+			}
+			fmt.Fprintf(&buf, `{"id": %d, "name": "n%d", "tags": ["a", "b"]}`, j, i)
+		}
+		buf.WriteString("]")
+		docs = append(docs, buf.Bytes())
+	}
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := sch.ValidateBytes(docs[i%len(docs)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		cv := sch.CachedValidator(len(docs))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := cv.ValidateBytes(docs[i%len(docs)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestRefErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{
+			"documentNotFound",
+			`{"properties": {"a": {"$ref": "missing.json"}}}`,
+			`cannot load http://example.com/missing.json, referred by $ref in http://example.com/schema.json#/properties/a: missing.json not available`,
+		},
+		{
+			"pointerNotFound",
+			`{"properties": {"a": {"$ref": "other.json#/does/not/exist"}}}`,
+			`json-pointer "/does/not/exist" not found in http://example.com/other.json, referred by $ref in http://example.com/schema.json#/properties/a`,
+		},
+		{
+			"anchorNotFound",
+			`{"items": {"$ref": "other.json#foo"}}`,
+			`anchor "foo" not found in http://example.com/other.json, referred by $ref in http://example.com/schema.json#/items`,
+		},
+		{
+			"localPointerNotFound",
+			`{"$ref": "#/$defs/missing"}`,
+			`json-pointer "/$defs/missing" not found in http://example.com/schema.json, referred by $ref in http://example.com/schema.json#`,
+		},
+		{
+			"nested",
+			`{"$ref": "other.json#/$defs/bad"}`,
+			`json-pointer "/$defs/missing" not found in http://example.com/schema.json, referred by $ref in http://example.com/other.json#/$defs/bad`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := jsonschema.NewCompiler()
+			c.LoadURL = func(s string) (io.ReadCloser, error) {
+				return nil, fmt.Errorf("%s not available", path.Base(s))
+			}
+			for url, doc := range map[string]string{
+				"http://example.com/schema.json": test.schema,
+				"http://example.com/other.json":  `{"$defs": {"bad": {"$ref": "schema.json#/$defs/missing"}}}`,
+			} {
+				if err := c.AddResource(url, strings.NewReader(doc)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := c.Compile("http://example.com/schema.json")
+			if err == nil {
+				t.Fatal("error expected")
+			}
+			if got := err.Error(); !strings.HasSuffix(got, test.want) {
+				t.Errorf("got %q, want suffix %q", got, test.want)
+			}
+		})
+	}
+
+	t.Run("wrapsLoaderError", func(t *testing.T) {
+		c := jsonschema.NewCompiler()
+		c.LoadURL = func(s string) (io.ReadCloser, error) {
+			return nil, os.ErrNotExist
+		}
+		if err := c.AddResource("http://example.com/schema.json", strings.NewReader(`{"$ref": "missing.json"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Compile("http://example.com/schema.json"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("got %v, want ErrNotExist", err)
+		}
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"type": "object",
+		"required": ["name"],
+		"properties": {"name": {"type": "string"}}
+	}`)
+	var got string
+	h := sch.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/json", `{"name": "john"}`, http.StatusNoContent},
+		{"application/json; charset=utf-8", `{"name": "john"}`, http.StatusNoContent},
+		{"application/merge-patch+json", `{"name": "john"}`, http.StatusNoContent},
+		{"application/json", `{"name": 1}`, http.StatusBadRequest},
+		{"application/json", `{}`, http.StatusBadRequest},
+		{"application/json", `{"name": `, http.StatusBadRequest},
+		{"text/plain", `{"name": 1}`, http.StatusNoContent},
+		{"", `not json`, http.StatusNoContent},
+	}
+	for _, test := range tests {
+		got = ""
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s %s: got status %d, want %d", test.contentType, test.body, rec.Code, test.status)
+			continue
+		}
+		if rec.Code == http.StatusNoContent && got != test.body {
+			t.Errorf("%s %s: next got body %q", test.contentType, test.body, got)
+		}
+	}
+
+	// invalid body gets basic output
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name": 1}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content-type: got %q", ct)
+	}
+	var out jsonschema.Basic
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Valid || len(out.Errors) == 0 || out.Errors[len(out.Errors)-1].InstanceLocation != "/name" {
+		t.Errorf("got %+v", out)
+	}
+
+	// body larger than limit is rejected
+	small := sch.MiddlewareN(h, 16)
+	for body, status := range map[string]int{
+		`{"name": "john"}`:       http.StatusNoContent,
+		`{"name": "john smith"}`: http.StatusRequestEntityTooLarge,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		small.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Errorf("%s: got status %d, want %d", body, rec.Code, status)
+		}
+	}
+
+	// body that cannot be validated is not client error
+	loop, err := jsonschema.NewCompiler().Compile("testdata/loop-validate.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"prop": 1}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	loop.Middleware(h).ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("infinite loop: got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestTrivial(t *testing.T) {
+	tests := []struct {
+		schema string
+		value  bool
+		ok     bool
+	}{
+		{`true`, true, true},
+		{`false`, false, true},
+		{`{}`, true, true},
+		{`{"title": "any", "description": "anything", "examples": [1]}`, true, true},
+		{`{"x-custom": 1, "$comment": "unknown keywords are ignored"}`, true, true},
+		{`{"format": "email"}`, true, true}, // format is annotation by default
+		{`{"type": "string"}`, false, false},
+		{`{"minimum": 1}`, false, false},
+		{`{"properties": {"a": true}}`, false, false},
+		{`{"not": false}`, false, false},
+		{`{"$ref": "#/$defs/empty", "$defs": {"empty": {}}}`, false, false},
+	}
+	for _, test := range tests {
+		sch := jsonschema.MustCompileString("schema.json", test.schema)
+		value, ok := sch.Trivial()
+		if value != test.value || ok != test.ok {
+			t.Errorf("%s: got (%t, %t), want (%t, %t)", test.schema, value, ok, test.value, test.ok)
+		}
+	}
+
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	if err := c.AddResource("schema.json", strings.NewReader(`{"format": "email"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.MustCompile("schema.json").Trivial(); ok {
+		t.Error("asserted format must not be trivial")
+	}
+}
+
+// countingLoader is a ResourceLoader, which counts the loads of next.
+type countingLoader struct {
+	next  jsonschema.ResourceLoader
+	count map[string]int
+}
+
+func (l *countingLoader) Load(uri string) (io.ReadCloser, error) {
+	l.count[uri]++
+	return l.next.Load(uri)
+}
+
+// ctxLoader is a ResourceLoader, which also has LoadContext.
+type ctxLoader struct {
+	ctx context.Context
+}
+
+func (l *ctxLoader) Load(uri string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("Load must not be used")
+}
+
+func (l *ctxLoader) LoadContext(ctx context.Context, uri string) (io.ReadCloser, error) {
+	l.ctx = ctx
+	return io.NopCloser(strings.NewReader(`{"type": "string"}`)), nil
+}
+
+func TestResourceLoader(t *testing.T) {
+	docs := map[string]string{
+		"map:///schema.json": `{"properties": {"a": {"$ref": "name.json"}, "b": {"$ref": "name.json"}}}`,
+		"map:///name.json":   `{"type": "string"}`,
+	}
+	l := &countingLoader{
+		next: jsonschema.LoaderFunc(func(uri string) (io.ReadCloser, error) {
+			doc, ok := docs[uri]
+			if !ok {
+				return nil, fmt.Errorf("%s not found", uri)
+			}
+			return io.NopCloser(strings.NewReader(doc)), nil
+		}),
+		count: make(map[string]int),
+	}
+	c := jsonschema.NewCompiler()
+	c.Loader = l
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		t.Fatalf("LoadURL must not be used, if Loader is set")
+		return nil, nil
+	}
+	sch, err := c.Compile("map:///schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sch.Validate(decodeString(t, `{"a": 1}`)); err == nil {
+		t.Error("validation must fail")
+	}
+	for _, uri := range []string{"map:///schema.json", "map:///name.json"} {
+		if got := l.count[uri]; got != 1 {
+			t.Errorf("%s: loaded %d times, want 1", uri, got)
+		}
+	}
+
+	t.Run("LoadContext", func(t *testing.T) {
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "value")
+		l := &ctxLoader{}
+		c := jsonschema.NewCompiler()
+		c.Loader = l
+		if _, err := c.CompileContext(ctx, "map:///name.json"); err != nil {
+			t.Fatal(err)
+		}
+		if l.ctx == nil || l.ctx.Value(key{}) != "value" {
+			t.Error("LoadContext must get ctx passed to CompileContext")
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package jsonschema
+
+import "bytes"
+
+// Validator validates json documents against a schema, reusing its buffers
+// across validations. It is meant for validating many documents in a loop.
+// Validating a decoded document that is valid does not allocate, unless
+// the schema uses keywords that need to, such as unevaluatedProperties,
+// or numbers that are not integers need comparison.
+//
+// A Validator is not safe for concurrent use. Use one Validator per goroutine.
+type Validator struct {
+	s  *Schema
+	vd validator
+	r  bytes.Reader
+}
+
+// Validator returns a new Validator, which validates against s.
+func (s *Schema) Validator() *Validator {
+	return &Validator{
+		s:  s,
+		vd: validator{scope: make([]schemaRef, 0, 32), loc: make([]byte, 0, 64)},
+	}
+}
+
+// Validate validates given doc, like Schema.Validate.
+func (v *Validator) Validate(doc interface{}) error {
+	_, err := v.s.validateWith(&v.vd, doc, "")
+	return err
+}
+
+// ValidateBytes decodes json document b and validates it, like Schema.ValidateBytes.
+func (v *Validator) ValidateBytes(b []byte) error {
+	v.r.Reset(b)
+	doc, p, err := v.s.decodePositions(&v.r)
+	if err != nil {
+		return err
+	}
+	err = v.Validate(doc)
+	if ve, ok := err.(*ValidationError); ok && p != nil {
+		p.fill(ve)
+	}
+	return err
+}
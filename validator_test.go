@@ -0,0 +1,93 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6"
+)
+
+func BenchmarkValidate(b *testing.B) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"type": "object",
+		"required": ["id", "tags", "address"],
+		"properties": {
+			"id": {"type": "integer", "minimum": 1},
+			"name": {"type": "string", "maxLength": 64},
+			"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}},
+			"address": {"$ref": "#/$defs/address"}
+		},
+		"additionalProperties": false,
+		"$defs": {
+			"address": {
+				"type": "object",
+				"properties": {
+					"street": {"type": "string"},
+					"zip": {"type": "string", "minLength": 5}
+				}
+			}
+		}
+	}`)
+	var doc interface{}
+	if err := json.Unmarshal([]byte(`{
+		"id": 42,
+		"name": "john",
+		"tags": ["a", "b", "c"],
+		"address": {"street": "main", "zip": "12345"}
+	}`), &doc); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("Schema", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := sch.Validate(doc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Validator", func(b *testing.B) {
+		b.ReportAllocs()
+		v := sch.Validator()
+		for i := 0; i < b.N; i++ {
+			if err := v.Validate(doc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestValidator(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"properties": {
+			"tags": {"items": {"$ref": "#/$defs/tag"}}
+		},
+		"$defs": {"tag": {"type": "string"}}
+	}`)
+	v := sch.Validator()
+	for i := 0; i < 3; i++ {
+		if err := v.ValidateBytes([]byte(`{"tags": ["a", "b"]}`)); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		err := v.ValidateBytes([]byte(`{"tags": ["a", 1]}`))
+		ve, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			t.Fatalf("#%d: want ValidationError, got %v", i, err)
+		}
+		leaves := ve.LeafErrors()
+		if len(leaves) != 1 || leaves[0].KeywordLocation != "/properties/tags/items/$ref/type" {
+			t.Fatalf("#%d: got %v", i, leaves)
+		}
+		if err := v.ValidateBytes([]byte(`{`)); err == nil {
+			t.Fatalf("#%d: want error for invalid json", i)
+		}
+	}
+
+	doc := decodeString(t, `{"tags": ["a", "b"], "name": "john"}`)
+	if allocs := testing.AllocsPerRun(100, func() {
+		if err := v.Validate(doc); err != nil {
+			t.Fatal(err)
+		}
+	}); allocs != 0 {
+		t.Errorf("valid document: got %v allocs, want 0", allocs)
+	}
+}
@@ -0,0 +1,332 @@
+package jsonschema
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Walk calls fn for s and each subschema reachable from s, in depth-first order.
+// Subschemas referred by $ref, $recursiveRef and $dynamicRef are visited as
+// children of the referring schema.
+//
+// path is the json-pointer of keyword location relative to s, for example
+// "/properties/name/$ref". If fn returns false, subschemas of that schema are
+// not visited. A schema that is already being visited up the path, i.e. one that
+// refers back to itself, is not visited again. So Walk terminates on recursive schemas.
+func (s *Schema) Walk(fn func(path string, s *Schema) bool) {
+	s.walk("", nil, fn)
+}
+
+func (s *Schema) walk(path string, ancestors []*Schema, fn func(path string, s *Schema) bool) {
+	for _, a := range ancestors {
+		if a == s {
+			return
+		}
+	}
+	if !fn(path, s) {
+		return
+	}
+	ancestors = append(ancestors, s)
+	visit := func(sch *Schema, kw ...string) {
+		if sch == nil {
+			return
+		}
+		p := path
+		for _, tok := range kw {
+			p += "/" + escapePtr(tok)
+		}
+		sch.walk(p, ancestors, fn)
+	}
+	visitList := func(list []*Schema, kw string) {
+		for i, sch := range list {
+			visit(sch, kw, strconv.Itoa(i))
+		}
+	}
+	visitMap := func(m map[string]*Schema, kw string) {
+		for _, pname := range sortedKeys(m) {
+			visit(m[pname], kw, pname)
+		}
+	}
+
+	visit(s.Ref, "$ref")
+	visit(s.RecursiveRef, "$recursiveRef")
+	visit(s.DynamicRef, "$dynamicRef")
+	visit(s.Not, "not")
+	visitList(s.AllOf, "allOf")
+	visitList(s.AnyOf, "anyOf")
+	visitList(s.OneOf, "oneOf")
+	visit(s.If, "if")
+	visit(s.Then, "then")
+	visit(s.Else, "else")
+
+	// object
+	visitMap(s.Properties, "properties")
+	visit(s.PropertyNames, "propertyNames")
+	patterns := make([]Regexp, 0, len(s.PatternProperties))
+	for re := range s.PatternProperties {
+		patterns = append(patterns, re)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		return patterns[i].String() < patterns[j].String()
+	})
+	for _, re := range patterns {
+		visit(s.PatternProperties[re], "patternProperties", re.String())
+	}
+	if sch, ok := s.AdditionalProperties.(*Schema); ok {
+		visit(sch, "additionalProperties")
+	}
+	deps := make(map[string]*Schema)
+	for pname, dep := range s.Dependencies {
+		if sch, ok := dep.(*Schema); ok {
+			deps[pname] = sch
+		}
+	}
+	visitMap(deps, "dependencies")
+	visitMap(s.DependentSchemas, "dependentSchemas")
+	visit(s.UnevaluatedProperties, "unevaluatedProperties")
+
+	// array
+	switch items := s.Items.(type) {
+	case *Schema:
+		visit(items, "items")
+	case []*Schema:
+		visitList(items, "items")
+	}
+	if sch, ok := s.AdditionalItems.(*Schema); ok {
+		visit(sch, "additionalItems")
+	}
+	visitList(s.PrefixItems, "prefixItems")
+	visit(s.Items2020, "items")
+	visit(s.Contains, "contains")
+	visit(s.UnevaluatedItems, "unevaluatedItems")
+
+	// string
+	visit(s.ContentSchema, "contentSchema")
+}
+
+// Resources returns urls of the documents s is loaded from, including
+// those referred transitively via $ref, $recursiveRef, $dynamicRef and
+// custom $schema. Each url appears once, in the order Walk reaches it.
+// Builtin metaschemas are not included.
+//
+// This is useful to know which files to watch, or which cache entries
+// to invalidate, to recompile s when its sources change.
+func (s *Schema) Resources() []string {
+	var urls []string
+	seen := make(map[string]bool)
+	visited := make(map[*Schema]bool)
+	var visit func(sch *Schema)
+	visit = func(sch *Schema) {
+		sch.Walk(func(_ string, sch *Schema) bool {
+			if visited[sch] {
+				return false
+			}
+			visited[sch] = true
+			if _, ok := vocabSchemas[sch.docURL]; ok || findDraft(sch.docURL) != nil {
+				return false
+			}
+			if !seen[sch.docURL] {
+				seen[sch.docURL] = true
+				urls = append(urls, sch.docURL)
+			}
+			if sch.meta != nil {
+				visit(sch.meta)
+			}
+			return true
+		})
+	}
+	visit(s)
+	return urls
+}
+
+func sortedKeys(m map[string]*Schema) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Subschema returns the subschema of s at given json-pointer ptr.
+// ptr may also be given as uri fragment like "#/properties/name", or
+// as plain-name fragment like "#address", which refers to the subschema
+// with that $anchor (or $id "#address" in draft-07 and earlier) in the
+// resource of s.
+//
+// If ptr passes through a schema with $ref, and the next token is not
+// a keyword of that schema, ptr is resolved against the $ref target. For
+// example "/properties/address/properties/street", where address is a $ref.
+//
+// Subschemas in $defs and definitions, that are not referred by s, are
+// compiled on first use, with the Compiler that compiled s. So Subschema
+// must not be called concurrently with other use of that Compiler.
+func (s *Schema) Subschema(ptr string) (*Schema, error) {
+	if strings.HasPrefix(ptr, "#") {
+		u, err := url.PathUnescape(ptr[1:])
+		if err != nil {
+			return nil, fmt.Errorf("jsonschema: invalid json-pointer %q: %v", ptr, err)
+		}
+		if u != "" && !strings.HasPrefix(u, "/") {
+			base, _ := split(s.Location)
+			return s.compileURL(base+"#"+u, ptr)
+		}
+		ptr = u
+	}
+	if ptr == "" {
+		return s, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("jsonschema: invalid json-pointer %q", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, tok := range tokens {
+		tok = strings.ReplaceAll(tok, "~1", "/")
+		tokens[i] = strings.ReplaceAll(tok, "~0", "~")
+	}
+
+	sch := s
+	for len(tokens) > 0 {
+		next, n := sch.child(tokens)
+		if next == nil && len(tokens) >= 2 && (tokens[0] == "$defs" || tokens[0] == "definitions") {
+			// not retained in compiled schema, so compiled from the document
+			sub, err := sch.compileURL(sch.Location+"/"+tokens[0]+"/"+escape(tokens[1]), ptr)
+			if err != nil {
+				return nil, err
+			}
+			next, n = sub, 2
+		}
+		for next == nil && sch.Ref != nil {
+			sch = sch.Ref
+			next, n = sch.child(tokens)
+		}
+		if next == nil {
+			return nil, fmt.Errorf("jsonschema: %s not found in %s", ptr, s.Location)
+		}
+		sch, tokens = next, tokens[n:]
+	}
+	return sch, nil
+}
+
+// compileURL returns the schema at absolute url loc, in the document of s.
+// It is compiled by s.compiler, if not compiled already. ptr is the argument
+// of Subschema, used in errors.
+func (s *Schema) compileURL(loc, ptr string) (*Schema, error) {
+	if s.compiler == nil {
+		return nil, fmt.Errorf("jsonschema: %s not found in %s", ptr, s.Location)
+	}
+	r, ok := s.compiler.resources[s.docURL]
+	if !ok {
+		return nil, fmt.Errorf("jsonschema: %s not found in %s", ptr, s.Location)
+	}
+	sch, err := s.compiler.compileRef(r, nil, "#", r, loc)
+	if err != nil {
+		var fe *fragmentError
+		if errors.As(err, &fe) {
+			return nil, fmt.Errorf("jsonschema: %s not found in %s", ptr, s.Location)
+		}
+		return nil, err
+	}
+	return sch, nil
+}
+
+// child returns the subschema of s at leading tokens, along with number
+// of tokens consumed. returns nil, if not found.
+func (s *Schema) child(tokens []string) (*Schema, int) {
+	index := func(list []*Schema) (*Schema, int) {
+		if len(tokens) < 2 {
+			return nil, 0
+		}
+		i, err := strconv.Atoi(tokens[1])
+		if err != nil || i < 0 || i >= len(list) || strconv.Itoa(i) != tokens[1] {
+			return nil, 0
+		}
+		return list[i], 2
+	}
+	prop := func(m map[string]*Schema) (*Schema, int) {
+		if len(tokens) < 2 || m[tokens[1]] == nil {
+			return nil, 0
+		}
+		return m[tokens[1]], 2
+	}
+	self := func(sch *Schema) (*Schema, int) {
+		if sch == nil {
+			return nil, 0
+		}
+		return sch, 1
+	}
+	schema := func(v interface{}) (*Schema, int) {
+		sch, _ := v.(*Schema)
+		return self(sch)
+	}
+
+	switch tokens[0] {
+	case "$ref":
+		return self(s.Ref)
+	case "$recursiveRef":
+		return self(s.RecursiveRef)
+	case "$dynamicRef":
+		return self(s.DynamicRef)
+	case "not":
+		return self(s.Not)
+	case "allOf":
+		return index(s.AllOf)
+	case "anyOf":
+		return index(s.AnyOf)
+	case "oneOf":
+		return index(s.OneOf)
+	case "if":
+		return self(s.If)
+	case "then":
+		return self(s.Then)
+	case "else":
+		return self(s.Else)
+	case "properties":
+		return prop(s.Properties)
+	case "propertyNames":
+		return self(s.PropertyNames)
+	case "patternProperties":
+		if len(tokens) >= 2 {
+			for re, sch := range s.PatternProperties {
+				if re.String() == tokens[1] {
+					return sch, 2
+				}
+			}
+		}
+	case "additionalProperties":
+		return schema(s.AdditionalProperties)
+	case "dependencies":
+		if len(tokens) >= 2 {
+			if sch, ok := s.Dependencies[tokens[1]].(*Schema); ok {
+				return sch, 2
+			}
+		}
+	case "dependentSchemas":
+		return prop(s.DependentSchemas)
+	case "unevaluatedProperties":
+		return self(s.UnevaluatedProperties)
+	case "items":
+		switch items := s.Items.(type) {
+		case *Schema:
+			return self(items)
+		case []*Schema:
+			return index(items)
+		}
+		return self(s.Items2020)
+	case "additionalItems":
+		return schema(s.AdditionalItems)
+	case "prefixItems":
+		return index(s.PrefixItems)
+	case "contains":
+		return self(s.Contains)
+	case "unevaluatedItems":
+		return self(s.UnevaluatedItems)
+	case "contentSchema":
+		return self(s.ContentSchema)
+	}
+	return nil, 0
+}
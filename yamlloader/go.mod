@@ -0,0 +1,10 @@
+module gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6/yamlloader
+
+go 1.19
+
+require (
+	gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6 v6.0.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+replace gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6 => ../
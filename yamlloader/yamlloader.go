@@ -0,0 +1,183 @@
+// Package yamlloader implements loading of yaml documents as schema resources
+// and validation of yaml instances.
+//
+// yaml documents are converted to the same representation that is used for
+// json documents. i.e objects are map[string]interface{}, arrays are []interface{}
+// and numbers are json.Number. So integer and float values in yaml behave
+// exactly as they do in json.
+//
+// To load schemas with ".yaml" or ".yml" extension, set Compiler.LoadURL:
+//
+//	compiler.LoadURL = yamlloader.Load
+//
+// Unlike json.Unmarshal, duplicate keys in a mapping are reported as error.
+// Non-string scalar keys such as 200 are converted to their string form,
+// since json object keys must be strings.
+package yamlloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// Load loads resource from given url using jsonschema.LoadURL.
+// If url has ".yaml" or ".yml" extension, the resource is converted to json.
+func Load(url string) (io.ReadCloser, error) {
+	r, err := jsonschema.LoadURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(url, ".yaml") && !strings.HasSuffix(url, ".yml") {
+		return r, nil
+	}
+	defer r.Close()
+	v, err := Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid yaml %s: %v", url, err)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// AddResource adds in-memory yaml resource to the compiler.
+//
+// Note that url must not have fragment
+func AddResource(c *jsonschema.Compiler, url string, r io.Reader) error {
+	doc, err := Decode(r)
+	if err != nil {
+		return fmt.Errorf("jsonschema: invalid yaml %s: %v", url, err)
+	}
+	return c.AddResourceJSON(url, doc)
+}
+
+// ValidateYAML validates the yaml document read from r against schema s.
+func ValidateYAML(s *jsonschema.Schema, r io.Reader) error {
+	v, err := Decode(r)
+	if err != nil {
+		return err
+	}
+	return s.Validate(v)
+}
+
+// Decode decodes single yaml document from r into json value.
+func Decode(r io.Reader) (interface{}, error) {
+	dec := yaml.NewDecoder(r)
+	var doc yaml.Node
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	var next yaml.Node
+	if err := dec.Decode(&next); err != io.EOF {
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New("multiple documents not allowed")
+	}
+	return toJSON(&doc)
+}
+
+var numberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+func toJSON(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return toJSON(n.Content[0])
+	case yaml.AliasNode:
+		return toJSON(n.Alias)
+	case yaml.SequenceNode:
+		arr := make([]interface{}, 0, len(n.Content))
+		for _, item := range n.Content {
+			v, err := toJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case yaml.MappingNode:
+		obj := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Kind == yaml.AliasNode {
+				k = k.Alias
+			}
+			if k.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: non-scalar mapping key not allowed", k.Line)
+			}
+			if k.ShortTag() == "!!merge" {
+				return nil, fmt.Errorf("line %d: merge key not supported", k.Line)
+			}
+			if _, ok := obj[k.Value]; ok {
+				return nil, fmt.Errorf("line %d: duplicate key %q", k.Line, k.Value)
+			}
+			val, err := toJSON(v)
+			if err != nil {
+				return nil, err
+			}
+			obj[k.Value] = val
+		}
+		return obj, nil
+	case yaml.ScalarNode:
+		return scalar(n)
+	}
+	return nil, fmt.Errorf("line %d: unexpected yaml node", n.Line)
+}
+
+func scalar(n *yaml.Node) (interface{}, error) {
+	switch n.ShortTag() {
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	case "!!int", "!!float":
+		if numberPattern.MatchString(n.Value) {
+			// keep the literal, so that big numbers do not lose precision
+			return json.Number(n.Value), nil
+		}
+		if n.ShortTag() == "!!int" {
+			// hex, octal or signed literals
+			var i int64
+			if err := n.Decode(&i); err != nil {
+				var u uint64
+				if err := n.Decode(&u); err != nil {
+					return nil, err
+				}
+				return json.Number(strconv.FormatUint(u, 10)), nil
+			}
+			return json.Number(strconv.FormatInt(i, 10)), nil
+		}
+		var f float64
+		if err := n.Decode(&f); err != nil {
+			return nil, err
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("line %d: %s is not valid json number", n.Line, n.Value)
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	case "!!str", "!!timestamp", "!!binary":
+		// timestamps and binary are kept as strings, so that
+		// format and contentEncoding can validate them
+		return n.Value, nil
+	}
+	return nil, fmt.Errorf("line %d: unsupported yaml tag %s", n.Line, n.ShortTag())
+}
@@ -0,0 +1,136 @@
+package yamlloader_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6"
+	"gitlab.edgecastcdn.net/edgecast/customer-config-management/libraries/jsonschema/v6/yamlloader"
+)
+
+func TestDecodeNumbers(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want json.Number
+	}{
+		{`1`, "1"},
+		{`-12`, "-12"},
+		{`123456789012345678901234567890`, "123456789012345678901234567890"},
+		{`0x1F`, "31"},
+		{`0o17`, "15"},
+		{`+7`, "7"},
+		{`0xFFFFFFFFFFFFFFFF`, "18446744073709551615"},
+		{`1.5`, "1.5"},
+		{`-2.5e3`, "-2.5e3"},
+		{`.5`, "0.5"},
+		{`1.0`, "1.0"},
+	}
+	for _, test := range tests {
+		v, err := yamlloader.Decode(strings.NewReader(test.yaml))
+		if err != nil {
+			t.Errorf("%s: %v", test.yaml, err)
+			continue
+		}
+		if got, ok := v.(json.Number); !ok || got != test.want {
+			t.Errorf("%s: got %#v, want %#v", test.yaml, v, test.want)
+		}
+	}
+
+	for _, doc := range []string{`.inf`, `-.inf`, `.nan`} {
+		if _, err := yamlloader.Decode(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: want error", doc)
+		}
+	}
+}
+
+func TestDecodeMappingKeys(t *testing.T) {
+	v, err := yamlloader.Decode(strings.NewReader("200: ok\ntrue: yes\n1.5: x\nname: john\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// yaml 1.2 has no yes/no booleans
+	want := map[string]interface{}{"200": "ok", "true": "yes", "1.5": "x", "name": "john"}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("got %#v, want %#v", v, want)
+	}
+
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"duplicate", "a: 1\na: 2\n", `duplicate key "a"`},
+		{"duplicate after conversion", "1: a\n'1': b\n", `duplicate key "1"`},
+		{"non-scalar", "? [a, b]\n: c\n", "non-scalar mapping key"},
+		{"merge", "base: &base {a: 1}\nderived:\n  <<: *base\n  b: 2\n", "merge key not supported"},
+	}
+	for _, test := range tests {
+		_, err := yamlloader.Decode(strings.NewReader(test.yaml))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %v, want error containing %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestDecodeMultipleDocuments(t *testing.T) {
+	if _, err := yamlloader.Decode(strings.NewReader("a: 1\n---\nb: 2\n")); err == nil {
+		t.Error("want error for multiple documents")
+	}
+	// document end marker alone is single document
+	if _, err := yamlloader.Decode(strings.NewReader("---\na: 1\n...\n")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateYAML(t *testing.T) {
+	sch := jsonschema.MustCompileString("schema.json", `{
+		"type": "object",
+		"properties": {"count": {"type": "integer", "maximum": 10}},
+		"required": ["count"]
+	}`)
+	if err := yamlloader.ValidateYAML(sch, strings.NewReader("count: 0x0A\n")); err != nil {
+		t.Error(err)
+	}
+
+	tests := []struct {
+		name       string
+		yaml       string
+		validation bool // whether error must be *jsonschema.ValidationError
+	}{
+		{"too large", "count: 11\n", true},
+		{"float", "count: 1.5\n", true},
+		{"missing", "other: 1\n", true},
+		{"syntax", "count: [1\n", false},
+		{"multiple documents", "count: 1\n---\ncount: 2\n", false},
+		{"duplicate key", "count: 1\ncount: 2\n", false},
+	}
+	for _, test := range tests {
+		err := yamlloader.ValidateYAML(sch, strings.NewReader(test.yaml))
+		if err == nil {
+			t.Errorf("%s: want error", test.name)
+			continue
+		}
+		if _, ok := err.(*jsonschema.ValidationError); ok != test.validation {
+			t.Errorf("%s: got %#v", test.name, err)
+		}
+	}
+}
+
+func TestAddResource(t *testing.T) {
+	c := jsonschema.NewCompiler()
+	if err := yamlloader.AddResource(c, "schema.yaml", strings.NewReader("type: integer\nmaximum: 1e1\n")); err != nil {
+		t.Fatal(err)
+	}
+	sch, err := c.Compile("schema.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yamlloader.ValidateYAML(sch, strings.NewReader("11")); err == nil {
+		t.Error("want error")
+	}
+	if err := yamlloader.AddResource(c, "bad.yaml", strings.NewReader("a: 1\na: 2\n")); err == nil {
+		t.Error("want error for invalid yaml")
+	}
+}